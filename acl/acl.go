@@ -0,0 +1,167 @@
+package acl
+
+import (
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	ActionAllow = "allow" // 允许
+	ActionDeny  = "deny"  // 拒绝
+)
+
+// Rule 访问控制规则，各条件均为空时匹配所有连接或报文，多个条件同时满足时规则才匹配
+type Rule struct {
+	// Groups 连接所在分组(设备角色)，连接加入其中任一分组即匹配
+	Groups []string `json:"groups" yaml:"groups"`
+	// Devices 连接绑定的设备id
+	Devices []string `json:"devices" yaml:"devices"`
+	// Routes 报文路由id
+	Routes []uint32 `json:"routes" yaml:"routes"`
+	// Types 报文类型
+	Types []uint16 `json:"types" yaml:"types"`
+	// Action 匹配时的动作 allow/deny
+	Action string `json:"action" yaml:"action"`
+	// Audit 匹配且允许时是否记录审计日志，拒绝总是记录
+	Audit bool `json:"audit" yaml:"audit"`
+}
+
+// Config 访问控制配置，对应config.yml中的aclconfig，规则按顺序匹配，第一条匹配的规则生效
+type Config struct {
+	// Default 没有规则匹配时的动作，默认allow
+	Default string `json:"default" yaml:"default"`
+	Rules   []Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadConfig 从config/config.yml的aclconfig读取访问控制配置
+func LoadConfig() (Config, error) {
+	conf := Config{}
+	vp := viper.New()
+	vp.AddConfigPath("config")
+	vp.SetConfigName("config.yml")
+	vp.SetConfigType("yml")
+	if err := vp.ReadInConfig(); err != nil {
+		return conf, err
+	}
+	if !vp.IsSet("aclconfig") {
+		return conf, errors.New("aclconfig not found in config.yml")
+	}
+	err := vp.UnmarshalKey("aclconfig", &conf)
+	if err != nil {
+		return conf, err
+	}
+	return conf, conf.validate()
+}
+
+func (c Config) validate() error {
+	if c.Default != "" && c.Default != ActionAllow && c.Default != ActionDeny {
+		return errors.New("acl default action must be allow or deny")
+	}
+	for _, rule := range c.Rules {
+		if rule.Action != ActionAllow && rule.Action != ActionDeny {
+			return errors.New("acl rule action must be allow or deny")
+		}
+	}
+	return nil
+}
+
+// ACL 按连接的设备及分组、报文的路由及类型控制访问，规则可在运行时重新加载
+type ACL struct {
+	lock    sync.RWMutex
+	conf    Config
+	connMgr connect.IConnectionMgr
+}
+
+// New 返回以conf控制访问的ACL，connMgr用于查询连接绑定的设备及所在分组
+func New(conf Config, connMgr connect.IConnectionMgr) (*ACL, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	return &ACL{conf: conf, connMgr: connMgr}, nil
+}
+
+// SetConnMgr 设置连接管理器
+func (a *ACL) SetConnMgr(connMgr connect.IConnectionMgr) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.connMgr = connMgr
+}
+
+// Reload 替换访问控制规则
+func (a *ACL) Reload(conf Config) error {
+	if err := conf.validate(); err != nil {
+		return err
+	}
+	a.lock.Lock()
+	a.conf = conf
+	a.lock.Unlock()
+	logger.Info("acl reloaded with %d rules", len(conf.Rules))
+	return nil
+}
+
+// Allow 连接是否允许发送pkt，拒绝及开启审计的规则匹配时记录审计日志
+func (a *ACL) Allow(conn connect.IConnection, pkt packet.IPacket) bool {
+	a.lock.RLock()
+	conf, connMgr := a.conf, a.connMgr
+	a.lock.RUnlock()
+	var device string
+	var groups []string
+	if connMgr != nil {
+		device = connect.DeviceOf(connMgr, conn.GetID())
+		if mgr, ok := connMgr.(connect.IGroupMgr); ok {
+			groups = mgr.Groups(conn.GetID())
+		}
+	}
+	for i, rule := range conf.Rules {
+		if !rule.match(device, groups, pkt) {
+			continue
+		}
+		allowed := rule.Action == ActionAllow
+		if !allowed || rule.Audit {
+			logger.Warn("acl audit: %s connection[id=%d,raddr:%s:%d,device:%s,groups:%v] msg[id:%d,type:%d] by rule[%d]",
+				rule.Action, conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), device, groups, pkt.GetID(), pkt.GetType(), i)
+		}
+		return allowed
+	}
+	if conf.Default == ActionDeny {
+		logger.Warn("acl audit: deny connection[id=%d,raddr:%s:%d,device:%s,groups:%v] msg[id:%d,type:%d] by default",
+			conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), device, groups, pkt.GetID(), pkt.GetType())
+		return false
+	}
+	return true
+}
+
+func (r *Rule) match(device string, groups []string, pkt packet.IPacket) bool {
+	if len(r.Routes) > 0 && !contains(r.Routes, pkt.GetID()) {
+		return false
+	}
+	if len(r.Types) > 0 && !contains(r.Types, pkt.GetType()) {
+		return false
+	}
+	if len(r.Devices) > 0 && !contains(r.Devices, device) {
+		return false
+	}
+	if len(r.Groups) > 0 {
+		for _, g := range groups {
+			if contains(r.Groups, g) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains[T comparable](values []T, v T) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
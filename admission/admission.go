@@ -0,0 +1,252 @@
+package admission
+
+import (
+	"dusnet/logger"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrDenied         = errors.New("source address denied")
+	ErrTooManyConns   = errors.New("too many connections")
+	ErrTooManyPerIP   = errors.New("too many connections from source ip")
+	ErrTooManyPerCIDR = errors.New("too many connections from source cidr")
+	ErrUnknownAddr    = errors.New("unknown source address")
+)
+
+// CIDRLimit 网段连接数限制
+type CIDRLimit struct {
+	CIDR     string `json:"cidr" yaml:"cidr"`
+	MaxConns int    `json:"maxconns" yaml:"maxconns"`
+}
+
+// Config 连接准入配置，对应config.yml中的admissionconfig，各上限为0时不限制
+type Config struct {
+	// MaxConns 总连接数上限
+	MaxConns int `json:"maxconns" yaml:"maxconns"`
+	// MaxConnsPerIP 每个源ip的连接数上限
+	MaxConnsPerIP int `json:"maxconnsperip" yaml:"maxconnsperip"`
+	// CIDRLimits 网段连接数上限，源ip落在多个网段时均需满足
+	CIDRLimits []CIDRLimit `json:"cidrlimits" yaml:"cidrlimits"`
+	// Allow 允许接入的ip或网段，不为空时只允许这些地址接入
+	Allow []string `json:"allow" yaml:"allow"`
+	// Deny 拒绝接入的ip或网段，优先于Allow
+	Deny []string `json:"deny" yaml:"deny"`
+}
+
+// Stats 准入统计
+type Stats struct {
+	Active         int    `json:"active"`         // 当前连接数
+	Accepted       uint64 `json:"accepted"`       // 累计接入数
+	Denied         uint64 `json:"denied"`         // 因allow/deny名单拒绝的连接数
+	RejectedTotal  uint64 `json:"rejectedTotal"`  // 因总连接数上限拒绝的连接数
+	RejectedPerIP  uint64 `json:"rejectedPerIP"`  // 因单ip上限拒绝的连接数
+	RejectedByCIDR uint64 `json:"rejectedByCIDR"` // 因网段上限拒绝的连接数
+}
+
+// LoadConfig 从config/config.yml的admissionconfig读取准入配置
+func LoadConfig() (Config, error) {
+	conf := Config{}
+	vp := viper.New()
+	vp.AddConfigPath("config")
+	vp.SetConfigName("config.yml")
+	vp.SetConfigType("yml")
+	if err := vp.ReadInConfig(); err != nil {
+		return conf, err
+	}
+	if !vp.IsSet("admissionconfig") {
+		return conf, errors.New("admissionconfig not found in config.yml")
+	}
+	err := vp.UnmarshalKey("admissionconfig", &conf)
+	return conf, err
+}
+
+// rules 解析后的准入规则
+type rules struct {
+	conf  Config
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	cidrs []netip.Prefix // 与conf.CIDRLimits一一对应
+}
+
+// Controller 连接准入控制，在接受连接时按名单及连接数上限拒绝，规则可在运行时重新加载
+type Controller struct {
+	lock    sync.Mutex
+	rules   *rules
+	total   int
+	perIP   map[netip.Addr]int
+	perCIDR []int // 与rules.cidrs一一对应
+	stats   Stats
+}
+
+// New 返回按conf准入的控制器
+func New(conf Config) (*Controller, error) {
+	r, err := parse(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Controller{rules: r, perIP: make(map[netip.Addr]int), perCIDR: make([]int, len(r.cidrs))}, nil
+}
+
+// Reload 替换准入规则，已接入的连接不受影响，网段连接数按当前连接重新统计
+func (c *Controller) Reload(conf Config) error {
+	r, err := parse(conf)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rules = r
+	c.perCIDR = make([]int, len(r.cidrs))
+	for ip, n := range c.perIP {
+		for i, prefix := range r.cidrs {
+			if prefix.Contains(ip) {
+				c.perCIDR[i] += n
+			}
+		}
+	}
+	logger.Info("admission reloaded,allow:%d,deny:%d,cidrLimits:%d", len(r.allow), len(r.deny), len(r.cidrs))
+	return nil
+}
+
+// Admit 检查来自addr的连接能否接入，可接入时返回连接关闭后需调用的release
+func (c *Controller) Admit(addr net.Addr) (func(), error) {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return nil, ErrUnknownAddr
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	r := c.rules
+	if matchAny(r.deny, ip) || (len(r.allow) > 0 && !matchAny(r.allow, ip)) {
+		c.stats.Denied++
+		return nil, ErrDenied
+	}
+	if r.conf.MaxConns > 0 && c.total >= r.conf.MaxConns {
+		c.stats.RejectedTotal++
+		return nil, ErrTooManyConns
+	}
+	if r.conf.MaxConnsPerIP > 0 && c.perIP[ip] >= r.conf.MaxConnsPerIP {
+		c.stats.RejectedPerIP++
+		return nil, ErrTooManyPerIP
+	}
+	for i, prefix := range r.cidrs {
+		if prefix.Contains(ip) && c.perCIDR[i] >= r.conf.CIDRLimits[i].MaxConns {
+			c.stats.RejectedByCIDR++
+			return nil, ErrTooManyPerCIDR
+		}
+	}
+	c.total++
+	c.perIP[ip]++
+	for i, prefix := range r.cidrs {
+		if prefix.Contains(ip) {
+			c.perCIDR[i]++
+		}
+	}
+	c.stats.Accepted++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.release(ip)
+		})
+	}, nil
+}
+
+func (c *Controller) release(ip netip.Addr) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.total--
+	if c.perIP[ip]--; c.perIP[ip] <= 0 {
+		delete(c.perIP, ip)
+	}
+	for i, prefix := range c.rules.cidrs {
+		if prefix.Contains(ip) && c.perCIDR[i] > 0 {
+			c.perCIDR[i]--
+		}
+	}
+}
+
+// Stats 返回准入统计
+func (c *Controller) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := c.stats
+	stats.Active = c.total
+	return stats
+}
+
+func parse(conf Config) (*rules, error) {
+	r := &rules{conf: conf}
+	var err error
+	if r.allow, err = parsePrefixes(conf.Allow); err != nil {
+		return nil, err
+	}
+	if r.deny, err = parsePrefixes(conf.Deny); err != nil {
+		return nil, err
+	}
+	for _, limit := range conf.CIDRLimits {
+		prefix, err := parsePrefix(limit.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		r.cidrs = append(r.cidrs, prefix)
+	}
+	return r, nil
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		prefix, err := parsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix 解析网段，单个ip视为只含该ip的网段
+func parsePrefix(v string) (netip.Prefix, error) {
+	v = strings.TrimSpace(v)
+	if strings.Contains(v, "/") {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return prefix, errors.New(fmt.Sprintf("invalid cidr %q,error:%v", v, err))
+		}
+		return prefix.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(v)
+	if err != nil {
+		return netip.Prefix{}, errors.New(fmt.Sprintf("invalid ip %q,error:%v", v, err))
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+func matchAny(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, ok := netip.AddrFromSlice(tcp.IP)
+		return ip.Unmap(), ok
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
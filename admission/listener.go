@@ -0,0 +1,54 @@
+package admission
+
+import (
+	"dusnet/logger"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Listener 包装监听器，接受连接时进行准入检查，未通过的连接直接关闭，不会进入连接管理器
+func (c *Controller) Listener(l net.Listener) net.Listener {
+	return &listener{Listener: l, ctrl: c}
+}
+
+type listener struct {
+	net.Listener
+	ctrl *Controller
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		release, err := l.ctrl.Admit(conn.RemoteAddr())
+		if err != nil {
+			logger.Warn("connection from %s rejected,error:%+v", conn.RemoteAddr(), err)
+			_ = conn.Close()
+			continue
+		}
+		return &admittedConn{Conn: conn, release: release}, nil
+	}
+}
+
+// admittedConn 关闭时归还准入计数
+type admittedConn struct {
+	net.Conn
+	release func()
+}
+
+func (a *admittedConn) Close() error {
+	a.release()
+	return a.Conn.Close()
+}
+
+// SyscallConn 透传底层套接字，供reactor使用
+func (a *admittedConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := a.Conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("connection does not expose raw socket")
+	}
+	return sc.SyscallConn()
+}
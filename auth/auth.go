@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+)
+
+// RouteID 握手报文使用的路由id，认证完成前连接只能发送此路由的报文
+const RouteID uint32 = 8000
+
+var (
+	ErrUnauthenticated = errors.New("connection not authenticated")
+	ErrAuthFailed      = errors.New("authentication failed")
+	ErrBadHandshake    = errors.New("malformed handshake data")
+)
+
+// State 连接的握手状态，多步握手时由Authenticator在各步之间保存中间数据
+type State struct {
+	Step      int    // 已完成的握手步数
+	Device    string // 设备声明或认证得到的设备id
+	Nonce     []byte // 服务端下发的随机数
+	PeerNonce []byte // 设备提供的随机数
+	Key       []byte // 认证使用的设备预共享密钥，可用于派生会话密钥
+}
+
+// Authenticator 认证器，每收到一个握手报文调用一次
+type Authenticator interface {
+	// Authenticate 处理一个握手报文，返回回复设备的数据；done为true时认证完成，设备id记录在state.Device
+	Authenticate(state *State, data []byte) (reply []byte, done bool, err error)
+}
+
+// Keys 设备预共享密钥
+type Keys interface {
+	Key(device string) ([]byte, bool)
+}
+
+// StaticKeys 固定的 设备id -> 预共享密钥
+type StaticKeys map[string][]byte
+
+func (k StaticKeys) Key(device string) ([]byte, bool) {
+	key, ok := k[device]
+	return key, ok
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// okReply 认证完成时回复设备的数据
+var okReply = []byte("ok")
+
+// nonceLen 随机数字节数
+const nonceLen = 16
+
+type tokenAuthenticator struct {
+	verify func(token string) (device string, ok bool)
+}
+
+// NewToken 令牌认证，设备发送令牌，verify校验令牌并返回对应的设备id
+func NewToken(verify func(token string) (device string, ok bool)) Authenticator {
+	return &tokenAuthenticator{verify: verify}
+}
+
+func (a *tokenAuthenticator) Authenticate(state *State, data []byte) ([]byte, bool, error) {
+	device, ok := a.verify(string(data))
+	if !ok || device == "" {
+		return nil, false, ErrAuthFailed
+	}
+	state.Device = device
+	return okReply, true, nil
+}
+
+type pskAuthenticator struct {
+	keys Keys
+}
+
+// NewPSK 预共享密钥认证，设备发送"设备id:密钥"，适用于只有明文链路的受限设备
+func NewPSK(keys Keys) Authenticator {
+	return &pskAuthenticator{keys: keys}
+}
+
+func (a *pskAuthenticator) Authenticate(state *State, data []byte) ([]byte, bool, error) {
+	i := bytes.IndexByte(data, ':')
+	if i <= 0 {
+		return nil, false, ErrBadHandshake
+	}
+	device := string(data[:i])
+	key, ok := a.keys.Key(device)
+	if !ok || !hmac.Equal(key, data[i+1:]) {
+		return nil, false, ErrAuthFailed
+	}
+	state.Device = device
+	state.Key = key
+	return okReply, true, nil
+}
+
+type hmacAuthenticator struct {
+	keys Keys
+}
+
+// NewHMAC HMAC挑战应答认证，密钥不在链路上传输：
+// 1.设备发送"设备id"或"设备id:hex(设备随机数)"，服务端回复hex(服务端随机数)；
+// 2.设备发送hex(HMAC-SHA256(密钥, 服务端随机数+设备随机数))，校验通过后回复"ok"
+func NewHMAC(keys Keys) Authenticator {
+	return &hmacAuthenticator{keys: keys}
+}
+
+func (a *hmacAuthenticator) Authenticate(state *State, data []byte) ([]byte, bool, error) {
+	switch state.Step {
+	case 0:
+		device, peerNonce := data, []byte(nil)
+		if i := bytes.IndexByte(data, ':'); i >= 0 {
+			device = data[:i]
+			n, err := hex.DecodeString(string(data[i+1:]))
+			if err != nil {
+				return nil, false, ErrBadHandshake
+			}
+			peerNonce = n
+		}
+		if len(device) == 0 {
+			return nil, false, ErrBadHandshake
+		}
+		key, ok := a.keys.Key(string(device))
+		if !ok {
+			return nil, false, ErrAuthFailed
+		}
+		nonce := make([]byte, nonceLen)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, false, err
+		}
+		state.Step = 1
+		state.Device = string(device)
+		state.Nonce = nonce
+		state.PeerNonce = peerNonce
+		state.Key = key
+		return []byte(hex.EncodeToString(nonce)), false, nil
+	case 1:
+		sum, err := hex.DecodeString(string(data))
+		if err != nil {
+			return nil, false, ErrBadHandshake
+		}
+		if !hmac.Equal(sum, Sign(state.Key, state.Nonce, state.PeerNonce)) {
+			return nil, false, ErrAuthFailed
+		}
+		state.Step = 2
+		return okReply, true, nil
+	}
+	return nil, false, ErrBadHandshake
+}
+
+// Sign 计算HMAC挑战应答的签名，设备端按相同方式计算
+func Sign(key []byte, nonce []byte, peerNonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	mac.Write(peerNonce)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/packet"
+	"encoding/hex"
+	"errors"
+)
+
+// Login 客户端侧与服务端NewHMAC认证器完成挑战应答握手，用于中继节点等以设备身份接入的链路；
+// 返回的状态可用于zcodec.Establish建立会话加密
+func Login(conn connect.IConnection, codec zcodec.Icodec, device string, key []byte) (State, error) {
+	peerNonce := make([]byte, nonceLen)
+	if _, err := rand.Read(peerNonce); err != nil {
+		return State{}, err
+	}
+	reply, err := exchange(conn, codec, []byte(device+":"+hex.EncodeToString(peerNonce)))
+	if err != nil {
+		return State{}, err
+	}
+	nonce, err := hex.DecodeString(string(reply))
+	if err != nil {
+		return State{}, errors.New("handshake rejected: " + string(reply))
+	}
+	reply, err = exchange(conn, codec, []byte(hex.EncodeToString(Sign(key, nonce, peerNonce))))
+	if err != nil {
+		return State{}, err
+	}
+	if !bytes.Equal(reply, okReply) {
+		return State{}, errors.New("handshake rejected: " + string(reply))
+	}
+	return State{Step: 2, Device: device, Nonce: nonce, PeerNonce: peerNonce, Key: key}, nil
+}
+
+// exchange 发送一个握手报文并读取回复
+func exchange(conn connect.IConnection, codec zcodec.Icodec, data []byte) ([]byte, error) {
+	pkt := &packet.Packet{}
+	pkt.ID = RouteID
+	pkt.Type = zcodec.TYPE_SYNC
+	pkt.Data = data
+	buf, err := codec.Encode(pkt)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+	reply, err := codec.Decode(conn)
+	if err != nil {
+		return nil, err
+	}
+	if reply.GetID() != RouteID {
+		return nil, ErrBadHandshake
+	}
+	return reply.GetData(), nil
+}
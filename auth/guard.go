@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"sync"
+	"time"
+)
+
+// defaultHandshakeTimeout 默认握手超时时间
+const defaultHandshakeTimeout = 10 * time.Second
+
+// Guard 连接认证守卫，连接建立后需在握手超时前经RouteID完成认证，认证前其他路由的报文均被拒绝
+type Guard struct {
+	authenticator Authenticator
+	timeout       time.Duration
+	wheel         *connect.TimingWheel
+	connMgr       connect.IConnectionMgr
+	lock          sync.Mutex
+	allowed       map[uint32]bool
+	sessions      map[connect.IConnection]*session
+	onAuth        func(conn connect.IConnection, state State) error
+}
+
+type session struct {
+	state         State
+	authenticated bool
+	trusted       bool // 经Trust认证的内部链路，不受握手超时限制
+	timer         *connect.Timer
+}
+
+// NewGuard 返回使用authenticator认证的守卫，timeout为握手超时时间，为0时默认10秒
+func NewGuard(authenticator Authenticator, timeout time.Duration) *Guard {
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	return &Guard{
+		authenticator: authenticator,
+		timeout:       timeout,
+		wheel:         connect.DefaultTimingWheel(),
+		allowed:       make(map[uint32]bool),
+		sessions:      make(map[connect.IConnection]*session),
+	}
+}
+
+// Allow 设置无需认证即可路由的路由id，如自行校验对端的节点间链路cluster.RouteID；
+// 只放行报文，连接仍受握手超时限制，内部链路需经Trust认证
+func (g *Guard) Allow(routeIDs ...uint32) *Guard {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, id := range routeIDs {
+		g.allowed[id] = true
+	}
+	return g
+}
+
+// OnAuthenticated 设置握手认证完成时的回调，如以握手状态建立加密会话，返回错误时认证失败
+// 经Grant认证的连接不回调
+func (g *Guard) OnAuthenticated(fn func(conn connect.IConnection, state State) error) {
+	g.onAuth = fn
+}
+
+// SetConnMgr 设置连接管理器，认证完成后将连接绑定到设备
+func (g *Guard) SetConnMgr(mgr connect.IConnectionMgr) {
+	g.connMgr = mgr
+}
+
+// Watch 连接建立时开始握手计时，超时未完成认证时调用onTimeout
+func (g *Guard) Watch(conn connect.IConnection, onTimeout func(conn connect.IConnection)) {
+	if conn == nil {
+		return
+	}
+	s := g.session(conn)
+	timer := g.wheel.AfterFunc(g.timeout, func() {
+		g.lock.Lock()
+		expired := !s.authenticated && !s.trusted && g.sessions[conn] == s
+		g.lock.Unlock()
+		if expired {
+			logger.Warn("connection[id=%d,raddr:%s:%d] handshake timeout", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort())
+			// 释放涉及关闭连接等IO，不阻塞时间轮
+			go onTimeout(conn)
+		}
+	})
+	g.lock.Lock()
+	s.timer = timer
+	g.lock.Unlock()
+}
+
+// Intercept 路由拦截器，认证前只放行RouteID及免认证路由
+func (g *Guard) Intercept(conn connect.IConnection, pkt packet.IPacket) error {
+	if pkt.GetID() == RouteID {
+		return nil
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.sessions[conn]
+	if ok && s.authenticated {
+		return nil
+	}
+	if g.allowed[pkt.GetID()] {
+		return nil
+	}
+	logger.Warn("connection[id=%d,raddr:%s:%d] send msg[id:%d] before authenticated", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), pkt.GetID())
+	return ErrUnauthenticated
+}
+
+// Handshake 处理连接的一个握手报文，返回回复设备的数据；认证失败时返回错误，连接应被释放
+func (g *Guard) Handshake(conn connect.IConnection, data []byte) ([]byte, error) {
+	s := g.session(conn)
+	g.lock.Lock()
+	if s.authenticated {
+		g.lock.Unlock()
+		return okReply, nil
+	}
+	state := s.state
+	g.lock.Unlock()
+
+	reply, done, err := g.authenticator.Authenticate(&state, data)
+	if err != nil {
+		logger.Warn("connection[id=%d,raddr:%s:%d] authenticate error,error:%+v", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), err)
+		return nil, err
+	}
+	if done && g.onAuth != nil {
+		if err := g.onAuth(conn, state); err != nil {
+			return nil, err
+		}
+	}
+	if done {
+		if err := g.bind(state.Device, conn); err != nil {
+			return nil, err
+		}
+	}
+	g.lock.Lock()
+	s.state = state
+	s.authenticated = done
+	timer := s.timer
+	g.lock.Unlock()
+	if done {
+		if timer != nil {
+			timer.Stop()
+		}
+		logger.Info("connection[id=%d,raddr:%s:%d] authenticated as device[%s]", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), state.Device)
+	}
+	return reply, nil
+}
+
+// Grant 连接已由其他方式认证(如TLS客户端证书)，直接标记为已认证并绑定到设备
+func (g *Guard) Grant(conn connect.IConnection, device string) error {
+	if err := g.bind(device, conn); err != nil {
+		return err
+	}
+	s := g.session(conn)
+	g.lock.Lock()
+	s.state = State{Device: device}
+	s.authenticated = true
+	timer := s.timer
+	g.lock.Unlock()
+	if timer != nil {
+		timer.Stop()
+	}
+	logger.Info("connection[id=%d,raddr:%s:%d] granted as device[%s]", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), device)
+	return nil
+}
+
+// Trust 连接已被认证为内部链路(如签名校验通过的集群节点)，不绑定设备，不再受握手超时限制，
+// 仍只能路由免认证路由
+func (g *Guard) Trust(conn connect.IConnection) {
+	s := g.session(conn)
+	g.lock.Lock()
+	first := !s.trusted
+	s.trusted = true
+	timer := s.timer
+	g.lock.Unlock()
+	if !first {
+		return
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	logger.Info("connection[id=%d,raddr:%s:%d] trusted as internal link", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort())
+}
+
+// Authenticated 连接是否已认证
+func (g *Guard) Authenticated(conn connect.IConnection) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.sessions[conn]
+	return ok && s.authenticated
+}
+
+// State 返回已认证连接的握手状态
+func (g *Guard) State(conn connect.IConnection) (State, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.sessions[conn]
+	if !ok || !s.authenticated {
+		return State{}, false
+	}
+	return s.state, true
+}
+
+// Forget 连接释放时清除其握手状态
+func (g *Guard) Forget(conn connect.IConnection) {
+	g.lock.Lock()
+	s, ok := g.sessions[conn]
+	delete(g.sessions, conn)
+	g.lock.Unlock()
+	if ok && s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+func (g *Guard) session(conn connect.IConnection) *session {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.sessions[conn]
+	if !ok {
+		s = &session{}
+		g.sessions[conn] = s
+	}
+	return s
+}
+
+// bind 连接管理器实现connect.IDeviceMgr时将连接绑定到设备
+func (g *Guard) bind(device string, conn connect.IConnection) error {
+	if mgr, ok := g.connMgr.(connect.IDeviceMgr); ok {
+		return mgr.BindDevice(device, conn.GetID())
+	}
+	return nil
+}
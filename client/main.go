@@ -3,7 +3,7 @@ package main
 import (
 	zcodec "dusnet/codec"
 	"dusnet/packet"
-	"fmt"
+	"dusnet/registry"
 	"github.com/google/uuid"
 	"math/rand"
 	"net"
@@ -11,10 +11,15 @@ import (
 )
 
 func main() {
+	// 通过mint_server1提供的注册中心发现服务节点
+	balancer, err := registry.NewBalancer(registry.NewRemote("tcp", "0.0.0.0:9000"), "dusnet")
+	if err != nil {
+		panic(err)
+	}
 	for i := 0; i < 100; i++ {
 		go func() {
 			for {
-				dialTest()
+				dialTest(balancer)
 				time.Sleep(time.Millisecond * 100)
 			}
 		}()
@@ -22,8 +27,12 @@ func main() {
 	select {}
 }
 
-func dialTest() {
-	dial, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", rand.Intn(3)+9000))
+func dialTest(balancer *registry.Balancer) {
+	ins, err := balancer.Pick()
+	if err != nil {
+		return
+	}
+	dial, err := net.Dial(ins.Network, ins.Address())
 	if err != nil {
 		return
 	}
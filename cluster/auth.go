@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dusnet/connect"
+	"dusnet/logger"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	maxClockSkew = 30 * time.Second // 消息时间与本地时间的最大偏差，超过时视为重放
+	nonceLen     = 16
+)
+
+var (
+	ErrNoSecret     = errors.New("cluster secret not set")
+	ErrBadSignature = errors.New("cluster message signature mismatch")
+	ErrStaleMessage = errors.New("cluster message expired")
+	ErrReplay       = errors.New("cluster message replayed")
+	ErrLinkMismatch = errors.New("cluster link bound to another node")
+)
+
+// SetSecret 设置集群内共享的密钥，节点间消息以HMAC-SHA256签名，未设置时不收发任何节点消息
+func (n *Node) SetSecret(secret []byte) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.secret = append([]byte{}, secret...)
+}
+
+// OnPeerLink 设置连接首次收到签名校验通过的对端消息时的回调，如将其标记为免握手的内部链路
+func (n *Node) OnPeerLink(fn func(conn connect.IConnection)) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.onLink = fn
+}
+
+// seal 编码并签名消息，包体为 签名(32) + json
+func (n *Node) seal(msg *Message) ([]byte, error) {
+	n.lock.RLock()
+	secret := n.secret
+	n.lock.RUnlock()
+	if len(secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	signed := *msg
+	signed.Time = time.Now().UnixNano()
+	signed.Nonce = make([]byte, nonceLen)
+	if _, err := rand.Read(signed.Nonce); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&signed)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return append(mac.Sum(nil), data...), nil
+}
+
+// open 校验签名、消息时间及nonce后解码消息
+func (n *Node) open(data []byte) (*Message, error) {
+	n.lock.RLock()
+	secret := n.secret
+	n.lock.RUnlock()
+	if len(secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	if len(data) < sha256.Size {
+		return nil, ErrBadSignature
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data[sha256.Size:])
+	if !hmac.Equal(mac.Sum(nil), data[:sha256.Size]) {
+		return nil, ErrBadSignature
+	}
+	msg := &Message{}
+	if err := json.Unmarshal(data[sha256.Size:], msg); err != nil {
+		return nil, err
+	}
+	skew := time.Since(time.Unix(0, msg.Time))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, ErrStaleMessage
+	}
+	if !n.remember(msg) {
+		return nil, ErrReplay
+	}
+	return msg, nil
+}
+
+// remember 记录时间窗口内收到的消息nonce，已收到过时返回false；超出窗口的消息已被时间校验拒绝，其nonce无需保留
+func (n *Node) remember(msg *Message) bool {
+	if len(msg.Nonce) != nonceLen {
+		return false
+	}
+	key := msg.Node + "/" + string(msg.Nonce)
+	now := time.Now()
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if now.Sub(n.pruned) > maxClockSkew {
+		for k, at := range n.seen {
+			if now.Sub(time.Unix(0, at)) > maxClockSkew {
+				delete(n.seen, k)
+			}
+		}
+		n.pruned = now
+	}
+	if _, ok := n.seen[key]; ok {
+		return false
+	}
+	n.seen[key] = msg.Time
+	return true
+}
+
+// admit 只接受已注册对端的消息，同一连接只能承载一个对端的消息
+func (n *Node) admit(conn connect.IConnection, msg *Message) (bool, error) {
+	n.lock.Lock()
+	if _, ok := n.peers[msg.Node]; !ok {
+		n.lock.Unlock()
+		logger.Warn("cluster node[%s] %s from unregistered node[%s] dropped", n.id, msg.Op, msg.Node)
+		return false, nil
+	}
+	bound, ok := n.links[conn]
+	if ok && bound != msg.Node {
+		n.lock.Unlock()
+		return false, ErrLinkMismatch
+	}
+	n.links[conn] = msg.Node
+	onLink := n.onLink
+	n.lock.Unlock()
+	if !ok && onLink != nil {
+		onLink(conn)
+	}
+	return true, nil
+}
@@ -0,0 +1,373 @@
+package cluster
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"dusnet/registry"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RouteID 节点间链路使用的路由id
+const RouteID uint32 = 5000
+
+var (
+	ErrDeviceNotFound = errors.New("device not found in cluster")
+	ErrPeerNotFound   = errors.New("peer node not found in cluster")
+	ErrNotPrimary     = errors.New("cluster node is not primary")
+)
+
+// Node 集群节点，维护 设备id -> 节点 的分布式映射，并通过节点间链路转发下行报文
+// 每个节点将本节点设备的变化广播给所有对端，因此每个节点都持有完整映射
+type Node struct {
+	id      string
+	codec0  zcodec.Icodec
+	lock    sync.RWMutex
+	local   map[string]connect.IConnection // 本节点持有的设备连接
+	remote  map[string]string              // 其他节点持有的设备 设备id -> 节点id
+	peers   map[string]*peer               // 节点id -> 到该节点的链路
+	watcher registry.Watcher
+	ha      *HA                            // 主备模式，为空时所有节点对等
+	secret  []byte                         // 节点间消息签名密钥
+	links   map[connect.IConnection]string // 对端发来消息的连接 -> 对端节点id
+	onLink  func(conn connect.IConnection)
+	seen    map[string]int64 // 时间窗口内收到的消息 发送方节点id/nonce -> 消息时间
+	pruned  time.Time        // 上次清理seen的时间
+}
+
+// New 返回集群节点，id需在集群内唯一，使用注册中心发现对端时应与server名称一致
+func New(id string) *Node {
+	return &Node{
+		id:     id,
+		codec0: zcodec.Default(),
+		local:  make(map[string]connect.IConnection),
+		remote: make(map[string]string),
+		peers:  make(map[string]*peer),
+		links:  make(map[connect.IConnection]string),
+		seen:   make(map[string]int64),
+	}
+}
+
+// ID 节点id
+func (n *Node) ID() string {
+	return n.id
+}
+
+// SetCodec 设置下发给设备及节点间链路使用的编解码器
+func (n *Node) SetCodec(codec zcodec.Icodec) {
+	n.codec0 = codec
+}
+
+// Join 监听注册中心中名称为service的服务，自动与其中的其他节点建立链路
+func (n *Node) Join(reg registry.Registry, service string) error {
+	watcher, err := reg.Watch(service)
+	if err != nil {
+		return err
+	}
+	n.watcher = watcher
+	go func() {
+		for {
+			instances, err := watcher.Next()
+			if err != nil {
+				if err != registry.ErrWatcherStopped {
+					logger.Error("cluster node[%s] watch peers error,error:%+v", n.id, err)
+				}
+				return
+			}
+			n.updatePeers(instances)
+		}
+	}()
+	return nil
+}
+
+func (n *Node) updatePeers(instances []*registry.ServiceInstance) {
+	alive := make(map[string]struct{}, len(instances))
+	for _, ins := range instances {
+		if ins.ID == n.id {
+			continue
+		}
+		alive[ins.ID] = struct{}{}
+		n.AddPeer(ins.ID, ins.Network, ins.Address())
+	}
+	n.lock.RLock()
+	var gone []string
+	for id := range n.peers {
+		if _, ok := alive[id]; !ok {
+			gone = append(gone, id)
+		}
+	}
+	n.lock.RUnlock()
+	for _, id := range gone {
+		n.RemovePeer(id)
+	}
+}
+
+// AddPeer 添加对端节点并向其同步本节点设备，已存在时忽略
+func (n *Node) AddPeer(id string, network string, address string) {
+	n.lock.Lock()
+	if _, ok := n.peers[id]; ok {
+		n.lock.Unlock()
+		return
+	}
+	p := newPeer(id, network, address, n)
+	n.peers[id] = p
+	n.lock.Unlock()
+	logger.Info("cluster node[%s] add peer[%s] at %s", n.id, id, address)
+	p.post(n.syncMessage(OpHello))
+}
+
+// RemovePeer 移除对端节点，并清除该节点持有的设备
+func (n *Node) RemovePeer(id string) {
+	n.lock.Lock()
+	p, ok := n.peers[id]
+	delete(n.peers, id)
+	n.purge(id)
+	n.lock.Unlock()
+	if ok {
+		p.close()
+		logger.Info("cluster node[%s] remove peer[%s]", n.id, id)
+	}
+}
+
+// purge 清除节点持有的设备映射，调用方需持有写锁
+func (n *Node) purge(nodeID string) {
+	for device, owner := range n.remote {
+		if owner == nodeID {
+			delete(n.remote, device)
+		}
+	}
+}
+
+// Bind 设备接入本节点，通知所有对端
+func (n *Node) Bind(device string, conn connect.IConnection) {
+	n.lock.Lock()
+	n.local[device] = conn
+	delete(n.remote, device)
+	n.lock.Unlock()
+	n.broadcast(&Message{Op: OpBind, Node: n.id, Device: device})
+}
+
+// Unbind 设备从本节点断开，通知所有对端；conn不为空时仅在设备当前绑定的就是conn时解绑，避免误删重连后的新连接
+func (n *Node) Unbind(device string, conn connect.IConnection) {
+	n.lock.Lock()
+	current, ok := n.local[device]
+	if !ok || (conn != nil && current != conn) {
+		n.lock.Unlock()
+		return
+	}
+	delete(n.local, device)
+	n.lock.Unlock()
+	n.broadcast(&Message{Op: OpUnbind, Node: n.id, Device: device})
+}
+
+// UnbindConn 连接断开时解绑其上的所有设备
+func (n *Node) UnbindConn(conn connect.IConnection) {
+	n.lock.Lock()
+	delete(n.links, conn)
+	n.lock.Unlock()
+	n.lock.RLock()
+	var devices []string
+	for device, c := range n.local {
+		if c == conn {
+			devices = append(devices, device)
+		}
+	}
+	n.lock.RUnlock()
+	for _, device := range devices {
+		n.Unbind(device, conn)
+	}
+}
+
+// LocalConns 返回本节点持有的所有设备连接
+func (n *Node) LocalConns() []connect.IConnection {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	conns := make([]connect.IConnection, 0, len(n.local))
+	for _, conn := range n.local {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// HA 返回主备模式，未启用时为空
+func (n *Node) HA() *HA {
+	return n.ha
+}
+
+// Locate 返回设备所在节点id
+func (n *Node) Locate(device string) (string, bool) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	if _, ok := n.local[device]; ok {
+		return n.id, true
+	}
+	nodeID, ok := n.remote[device]
+	return nodeID, ok
+}
+
+// Send 向设备下发报文，设备在其他节点时经节点间链路转发
+func (n *Node) Send(device string, pkt packet.IPacket) error {
+	n.lock.RLock()
+	conn, local := n.local[device]
+	nodeID, remote := n.remote[device]
+	p := n.peers[nodeID]
+	n.lock.RUnlock()
+	if local {
+		return n.deliver(device, conn, pkt)
+	}
+	if !remote {
+		return ErrDeviceNotFound
+	}
+	if p == nil {
+		return ErrPeerNotFound
+	}
+	return p.send(&Message{
+		Op:     OpForward,
+		Node:   n.id,
+		Device: device,
+		Packet: &Frame{ID: pkt.GetID(), Type: pkt.GetType(), Data: pkt.GetData()},
+	})
+}
+
+func (n *Node) deliver(device string, conn connect.IConnection, pkt packet.IPacket) error {
+	if !conn.Alive() {
+		n.Unbind(device, conn)
+		return ErrDeviceNotFound
+	}
+	buf, err := zcodec.EncodeFor(n.codec0, conn, pkt)
+	if err != nil {
+		return err
+	}
+	return conn.Write(buf)
+}
+
+// HandleMessage 处理对端节点经conn在RouteID路由上发来的消息，签名校验失败时返回错误，
+// 未注册对端的消息被丢弃
+func (n *Node) HandleMessage(conn connect.IConnection, data []byte) error {
+	msg, err := n.open(data)
+	if err != nil {
+		logger.Warn("cluster node[%s] reject msg from connection[id=%d,raddr:%s:%d],error:%+v",
+			n.id, conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), err)
+		return err
+	}
+	if msg.Node == n.id {
+		return nil
+	}
+	if ok, err := n.admit(conn, msg); !ok {
+		return err
+	}
+	switch msg.Op {
+	case OpHello, OpSync:
+		n.lock.Lock()
+		n.purge(msg.Node)
+		for _, device := range msg.Devices {
+			if _, ok := n.local[device]; !ok {
+				n.remote[device] = msg.Node
+			}
+		}
+		p := n.peers[msg.Node]
+		n.lock.Unlock()
+		if msg.Op == OpHello && p != nil {
+			// 对端可能刚重启，回送本节点设备
+			p.post(n.syncMessage(OpSync))
+			if n.ha != nil {
+				n.ha.replicateTo(p)
+			}
+		}
+	case OpBind:
+		n.lock.Lock()
+		n.remote[msg.Device] = msg.Node
+		n.lock.Unlock()
+	case OpUnbind:
+		n.lock.Lock()
+		if n.remote[msg.Device] == msg.Node {
+			delete(n.remote, msg.Device)
+		}
+		n.lock.Unlock()
+	case OpForward:
+		n.lock.RLock()
+		conn, ok := n.local[msg.Device]
+		n.lock.RUnlock()
+		if !ok || msg.Packet == nil {
+			logger.Warn("cluster node[%s] forward from node[%s] to device[%s] dropped,device not here", n.id, msg.Node, msg.Device)
+			return nil
+		}
+		pkt := &packet.Packet{}
+		pkt.ID = msg.Packet.ID
+		pkt.Type = msg.Packet.Type
+		pkt.Data = msg.Packet.Data
+		if err := n.deliver(msg.Device, conn, pkt); err != nil {
+			logger.Warn("cluster node[%s] deliver to device[%s] error,error:%+v", n.id, msg.Device, err)
+		}
+	case OpLeave:
+		n.lock.Lock()
+		n.purge(msg.Node)
+		n.lock.Unlock()
+	case OpHeartbeat, OpPromote, OpReplicate:
+		if n.ha == nil {
+			logger.Warn("cluster node[%s] not in master-slave mode,%s from node[%s] ignored", n.id, msg.Op, msg.Node)
+			return nil
+		}
+		n.ha.handleMessage(msg)
+	default:
+		return fmt.Errorf("cluster op %s not supported", msg.Op)
+	}
+	return nil
+}
+
+func (n *Node) syncMessage(op string) *Message {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	devices := make([]string, 0, len(n.local))
+	for device := range n.local {
+		devices = append(devices, device)
+	}
+	return &Message{Op: op, Node: n.id, Devices: devices}
+}
+
+// broadcast 异步发送消息给所有对端，不阻塞设备接入、断开等调用方
+func (n *Node) broadcast(msg *Message) {
+	for _, p := range n.peerList() {
+		p.post(msg)
+	}
+}
+
+// peerList 返回当前所有对端链路
+func (n *Node) peerList() []*peer {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	peers := make([]*peer, 0, len(n.peers))
+	for _, p := range n.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Stop 通知对端本节点下线并关闭所有链路
+func (n *Node) Stop() error {
+	if n.ha != nil {
+		n.ha.Stop()
+	}
+	if n.watcher != nil {
+		_ = n.watcher.Stop()
+	}
+	// 关闭链路前同步通知，异步发送的消息会随链路关闭丢弃
+	leave := &Message{Op: OpLeave, Node: n.id}
+	for _, p := range n.peerList() {
+		if err := p.send(leave); err != nil {
+			logger.Warn("cluster node[%s] %s to peer[%s] error,error:%+v", n.id, leave.Op, p.id, err)
+		}
+	}
+	n.lock.Lock()
+	peers := n.peers
+	n.peers = make(map[string]*peer)
+	n.lock.Unlock()
+	for _, p := range peers {
+		p.close()
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package cluster
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"sync"
+	"time"
+)
+
+// RedirectRouteID 主备模式下通知设备重连primary的路由id，包体为primary地址 host:port
+const RedirectRouteID uint32 = 5001
+
+// Role 主备模式下的节点角色
+type Role int32
+
+const (
+	RoleStandby Role = iota // 备节点，不接受设备连接
+	RolePrimary             // 主节点
+)
+
+func (r Role) String() string {
+	if r == RolePrimary {
+		return "primary"
+	}
+	return "standby"
+}
+
+const (
+	defaultHAInterval = time.Second
+	defaultHATimeout  = 3 * time.Second
+)
+
+// HAConfig 主备模式配置
+type HAConfig struct {
+	Role     Role          // 初始角色，集群中应只有一个节点以primary启动
+	Address  string        // 本节点对设备暴露的地址 host:port，成为primary后经重定向下发给设备
+	Priority int           // standby晋升优先级，越小越先晋升，多个standby应各不相同
+	Interval time.Duration // primary心跳间隔，默认1秒
+	Timeout  time.Duration // standby判定primary失效的心跳超时，默认3秒
+}
+
+// HA 主备模式，primary定时向standby发送心跳并复制会话状态，
+// standby心跳超时后按优先级晋升，晋升后任期加一，任期较小的primary收到新任期心跳后自动降级
+// 设备映射由集群节点自身同步，无需额外复制
+type HA struct {
+	node        *Node
+	conf        HAConfig
+	lock        sync.RWMutex
+	role        Role
+	term        uint64            // 当前任期
+	primary     string            // primary节点id
+	primaryAddr string            // primary对设备暴露的地址
+	lastBeat    time.Time         // 最近一次收到primary心跳的时间
+	sessions    map[string][]byte // 会话状态，primary写入后复制到standby
+	listeners   []func(Role)
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewHA 为集群节点启用主备模式
+func NewHA(node *Node, conf HAConfig) *HA {
+	if conf.Interval <= 0 {
+		conf.Interval = defaultHAInterval
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = defaultHATimeout
+	}
+	h := &HA{
+		node:     node,
+		conf:     conf,
+		role:     conf.Role,
+		lastBeat: time.Now(),
+		sessions: make(map[string][]byte),
+		stop:     make(chan struct{}),
+	}
+	if conf.Role == RolePrimary {
+		h.primary = node.id
+		h.primaryAddr = conf.Address
+	}
+	node.ha = h
+	return h
+}
+
+// Start 开始发送心跳或检测primary心跳
+func (h *HA) Start() {
+	logger.Info("cluster node[%s] started as %s", h.node.id, h.Role())
+	go h.loop()
+}
+
+// Stop 停止心跳
+func (h *HA) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+}
+
+// Role 当前角色
+func (h *HA) Role() Role {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.role
+}
+
+// IsPrimary 当前是否为primary
+func (h *HA) IsPrimary() bool {
+	return h.Role() == RolePrimary
+}
+
+// Primary 返回当前已知的primary节点id及其对设备暴露的地址
+func (h *HA) Primary() (string, string) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.primary, h.primaryAddr
+}
+
+// OnRoleChange 注册角色变化回调
+func (h *HA) OnRoleChange(fn func(Role)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.listeners = append(h.listeners, fn)
+}
+
+// SetSession 写入会话状态并复制到所有standby，仅primary可写
+func (h *HA) SetSession(key string, value []byte) error {
+	return h.updateSession(key, value)
+}
+
+// DeleteSession 删除会话状态并复制到所有standby，仅primary可写
+func (h *HA) DeleteSession(key string) error {
+	return h.updateSession(key, nil)
+}
+
+// Session 读取会话状态
+func (h *HA) Session(key string) ([]byte, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	value, ok := h.sessions[key]
+	return value, ok
+}
+
+func (h *HA) updateSession(key string, value []byte) error {
+	h.lock.Lock()
+	if h.role != RolePrimary {
+		h.lock.Unlock()
+		return ErrNotPrimary
+	}
+	if value == nil {
+		delete(h.sessions, key)
+	} else {
+		h.sessions[key] = value
+	}
+	term := h.term
+	h.lock.Unlock()
+	h.node.broadcast(&Message{Op: OpReplicate, Node: h.node.id, Term: term, Key: key, Value: value})
+	return nil
+}
+
+// replicateTo 向新加入的对端复制全量会话状态，仅primary执行
+func (h *HA) replicateTo(p *peer) {
+	h.lock.RLock()
+	if h.role != RolePrimary {
+		h.lock.RUnlock()
+		return
+	}
+	sessions := make(map[string][]byte, len(h.sessions))
+	for key, value := range h.sessions {
+		sessions[key] = value
+	}
+	msg := &Message{Op: OpReplicate, Node: h.node.id, Term: h.term, Address: h.primaryAddr, Full: true, Sessions: sessions}
+	h.lock.RUnlock()
+	p.post(msg)
+}
+
+// Redirect 通知设备重连primary，调用方随后应关闭连接
+func (h *HA) Redirect(conn connect.IConnection) error {
+	_, address := h.Primary()
+	if address == "" {
+		return ErrPeerNotFound
+	}
+	pkt := &packet.Packet{}
+	pkt.ID = RedirectRouteID
+	pkt.Type = zcodec.TYPE_SYNC
+	pkt.Data = []byte(address)
+	buf, err := zcodec.EncodeFor(h.node.codec0, conn, pkt)
+	if err != nil {
+		return err
+	}
+	return conn.Write(buf)
+}
+
+func (h *HA) loop() {
+	ticker := time.NewTicker(h.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+		h.lock.RLock()
+		role, term, lastBeat := h.role, h.term, h.lastBeat
+		h.lock.RUnlock()
+		if role == RolePrimary {
+			h.node.broadcast(&Message{Op: OpHeartbeat, Node: h.node.id, Term: term, Address: h.conf.Address})
+			continue
+		}
+		// 按优先级错开晋升时间，避免多个standby同时晋升
+		deadline := h.conf.Timeout + time.Duration(h.conf.Priority)*h.conf.Interval
+		if time.Since(lastBeat) > deadline {
+			h.promote()
+		}
+	}
+}
+
+func (h *HA) promote() {
+	h.lock.Lock()
+	if h.role == RolePrimary {
+		h.lock.Unlock()
+		return
+	}
+	h.term++
+	h.primary = h.node.id
+	h.primaryAddr = h.conf.Address
+	term := h.term
+	h.lock.Unlock()
+	logger.Warn("cluster node[%s] primary heartbeat timeout,promoted to primary with term %d", h.node.id, term)
+	h.node.broadcast(&Message{Op: OpPromote, Node: h.node.id, Term: term, Address: h.conf.Address})
+	h.setRole(RolePrimary)
+}
+
+func (h *HA) setRole(role Role) {
+	h.lock.Lock()
+	changed := h.role != role
+	h.role = role
+	listeners := h.listeners
+	h.lock.Unlock()
+	if !changed {
+		return
+	}
+	for _, fn := range listeners {
+		fn(role)
+	}
+}
+
+func (h *HA) handleMessage(msg *Message) {
+	switch msg.Op {
+	case OpHeartbeat, OpPromote:
+		h.lock.Lock()
+		// 任期较小的消息来自已被取代的primary，忽略；同任期时以节点id较小者为准
+		if msg.Term < h.term || (msg.Term == h.term && h.role == RolePrimary && h.node.id < msg.Node) {
+			h.lock.Unlock()
+			return
+		}
+		h.term = msg.Term
+		h.primary = msg.Node
+		h.primaryAddr = msg.Address
+		h.lastBeat = time.Now()
+		demoted := h.role == RolePrimary
+		h.lock.Unlock()
+		if demoted {
+			logger.Warn("cluster node[%s] found primary[%s] with term %d,demoted to standby", h.node.id, msg.Node, msg.Term)
+			h.setRole(RoleStandby)
+		}
+	case OpReplicate:
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if msg.Term < h.term || h.role == RolePrimary {
+			return
+		}
+		if msg.Full {
+			// 空的全量状态经json编码后为空，不能以Sessions是否为空判断
+			h.sessions = make(map[string][]byte, len(msg.Sessions))
+			for key, value := range msg.Sessions {
+				h.sessions[key] = value
+			}
+			return
+		}
+		if msg.Value == nil {
+			delete(h.sessions, msg.Key)
+		} else {
+			h.sessions[msg.Key] = msg.Value
+		}
+	}
+}
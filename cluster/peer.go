@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	peerDialTimeout = 3 * time.Second // 建立到对端链路的超时
+	peerQueueSize   = 1024            // 异步发送队列长度，队列满时丢弃消息
+)
+
+var errPeerClosed = errors.New("cluster peer closed")
+
+// peer 到对端节点的单向链路，本节点的消息经此链路发往对端server的RouteID路由
+type peer struct {
+	id      string
+	network string
+	address string
+	node    *Node
+	lock    sync.Mutex          // 保护链路切换及写入，拨号期间不持有
+	conn    connect.IConnection // 懒加载，写入失败后重建
+	closed  bool
+	queue   chan *Message // 异步发送的消息，由发送协程按序写出
+	done    chan struct{}
+}
+
+func newPeer(id string, network string, address string, node *Node) *peer {
+	p := &peer{
+		id:      id,
+		network: network,
+		address: address,
+		node:    node,
+		queue:   make(chan *Message, peerQueueSize),
+		done:    make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// post 异步发送消息，不阻塞调用方，队列满时丢弃
+func (p *peer) post(msg *Message) {
+	select {
+	case p.queue <- msg:
+	case <-p.done:
+	default:
+		logger.Warn("cluster node[%s] queue to peer[%s] full,%s dropped", p.node.id, p.id, msg.Op)
+	}
+}
+
+func (p *peer) loop() {
+	for {
+		select {
+		case msg := <-p.queue:
+			if err := p.send(msg); err != nil {
+				logger.Warn("cluster node[%s] %s to peer[%s] error,error:%+v", p.node.id, msg.Op, p.id, err)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// send 同步发送消息，链路未建立时先拨号，拨号期间不持有锁
+func (p *peer) send(msg *Message) error {
+	for {
+		p.lock.Lock()
+		conn := p.conn
+		p.lock.Unlock()
+		var dialed connect.IConnection
+		if conn == nil {
+			var err error
+			if dialed, err = connect.DialTimeout(p.network, p.address, peerDialTimeout); err != nil {
+				return err
+			}
+		}
+		p.lock.Lock()
+		if dialed != nil && (p.closed || p.conn != nil) {
+			// 已关闭或其他协程已建立链路
+			_ = dialed.Close()
+			dialed = nil
+		}
+		if p.closed {
+			p.lock.Unlock()
+			return errPeerClosed
+		}
+		if p.conn == nil && dialed == nil {
+			// 链路已因其他协程写入失败关闭，重新拨号
+			p.lock.Unlock()
+			continue
+		}
+		err := p.deliver(dialed, msg)
+		p.lock.Unlock()
+		return err
+	}
+}
+
+// deliver 写入消息，dialed不为空时为新建链路，先同步本节点设备，调用方需持有锁
+func (p *peer) deliver(dialed connect.IConnection, msg *Message) error {
+	if dialed != nil {
+		p.conn = dialed
+		if msg.Op != OpHello {
+			// 新建链路时对端可能刚重启，先同步本节点设备
+			if err := p.write(p.node.syncMessage(OpHello)); err != nil {
+				return err
+			}
+		}
+	}
+	return p.write(msg)
+}
+
+// write 在已建立的链路上写入消息，调用方需持有锁
+func (p *peer) write(msg *Message) error {
+	data, err := p.node.seal(msg)
+	if err != nil {
+		return err
+	}
+	pkt := &packet.Packet{}
+	pkt.ID = RouteID
+	pkt.Type = zcodec.TYPE_SYNC
+	pkt.Data = data
+	buf, err := p.node.codec0.Encode(pkt)
+	if err != nil {
+		return err
+	}
+	if err := p.conn.Write(buf); err != nil {
+		_ = p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (p *peer) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+	if p.conn != nil {
+		_ = p.conn.Close()
+		p.conn = nil
+	}
+}
@@ -0,0 +1,40 @@
+package cluster
+
+// 节点间链路上的消息类型，消息以json编码并签名后作为包体在RouteID路由上传输
+const (
+	OpHello   = "hello"   // 建链后发送本节点全部设备，对端收到后回送sync
+	OpSync    = "sync"    // 全量同步本节点设备
+	OpBind    = "bind"    // 设备接入本节点
+	OpUnbind  = "unbind"  // 设备从本节点断开
+	OpForward = "forward" // 转发下行报文给对端节点上的设备
+	OpLeave   = "leave"   // 节点下线
+
+	OpHeartbeat = "heartbeat" // 主备模式下primary定时发送的心跳
+	OpPromote   = "promote"   // 主备模式下standby晋升为primary
+	OpReplicate = "replicate" // 主备模式下primary向standby复制会话状态
+)
+
+// Message 节点间消息，以集群共享密钥签名
+type Message struct {
+	Op      string   `json:"op"`
+	Node    string   `json:"node"`              // 发送方节点id
+	Time    int64    `json:"time"`              // 发送时间，unix纳秒，用于拒绝过期重放
+	Nonce   []byte   `json:"nonce"`             // 随机数，时间窗口内重复出现的消息视为重放
+	Device  string   `json:"device,omitempty"`  // bind、unbind、forward的目标设备
+	Devices []string `json:"devices,omitempty"` // hello、sync时发送方持有的全部设备
+	Packet  *Frame   `json:"packet,omitempty"`  // forward的下行报文
+
+	Term     uint64            `json:"term,omitempty"`     // 主备任期，每次晋升加一
+	Address  string            `json:"address,omitempty"`  // primary对设备暴露的地址
+	Full     bool              `json:"full,omitempty"`     // replicate为全量同步，以Sessions替换全部会话状态
+	Sessions map[string][]byte `json:"sessions,omitempty"` // replicate的全量会话状态
+	Key      string            `json:"key,omitempty"`      // replicate的单个会话key
+	Value    []byte            `json:"value,omitempty"`    // replicate的单个会话值，为空表示删除
+}
+
+// Frame 转发的报文，由目标设备所在节点使用自己的编解码器编码后下发
+type Frame struct {
+	ID   uint32 `json:"id"`
+	Type uint16 `json:"type"`
+	Data []byte `json:"data"`
+}
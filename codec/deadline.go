@@ -0,0 +1,75 @@
+package zcodec
+
+import (
+	"dusnet/connect"
+	"dusnet/logger"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrHeaderTimeout = errors.New("packet header not completed in time")
+	ErrBodyTimeout   = errors.New("packet body not completed in time")
+)
+
+// 读取超时计数
+var headerTimeouts, bodyTimeouts uint64
+
+// TimeoutStats 读取超时统计
+type TimeoutStats struct {
+	Header uint64 `json:"header"` // 报文头未按时收全而断开的次数
+	Body   uint64 `json:"body"`   // 报文体未按时收全而断开的次数
+}
+
+// Timeouts 返回所有带超时保护的编解码器的读取超时统计
+func Timeouts() TimeoutStats {
+	return TimeoutStats{Header: atomic.LoadUint64(&headerTimeouts), Body: atomic.LoadUint64(&bodyTimeouts)}
+}
+
+// readHead 读取报文头的第一个字段，首字节到达后开始报文头计时
+func (c *codec) readHead(conn connect.IConnection, dc connect.IDeadlineConnection, buf []byte) error {
+	if err := conn.Read(buf[:1]); err != nil {
+		return err
+	}
+	if c.headerTimeout > 0 {
+		if err := dc.SetReadDeadline(time.Now().Add(c.headerTimeout)); err != nil {
+			return err
+		}
+	}
+	return c.headerErr(conn, conn.Read(buf[1:]))
+}
+
+// readBody 报文头收全后在报文体超时内读取报文体，读取后清除截止时间
+func (c *codec) readBody(conn connect.IConnection, dc connect.IDeadlineConnection, buf []byte) error {
+	deadline := time.Time{}
+	if c.bodyTimeout > 0 {
+		deadline = time.Now().Add(c.bodyTimeout)
+	}
+	if err := dc.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	if err := conn.Read(buf); err != nil {
+		return c.timeoutErr(conn, err, ErrBodyTimeout, &bodyTimeouts, c.bodyTimeout)
+	}
+	return dc.SetReadDeadline(time.Time{})
+}
+
+func (c *codec) headerErr(conn connect.IConnection, err error) error {
+	if err == nil {
+		return nil
+	}
+	return c.timeoutErr(conn, err, ErrHeaderTimeout, &headerTimeouts, c.headerTimeout)
+}
+
+// timeoutErr 读取超时时计数并返回对应错误，其他错误原样返回
+func (c *codec) timeoutErr(conn connect.IConnection, err error, timeoutErr error, counter *uint64, timeout time.Duration) error {
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		return err
+	}
+	atomic.AddUint64(counter, 1)
+	logger.Warn("connection[id=%d,raddr:%s:%d] %v within %s,disconnect", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), timeoutErr, timeout)
+	return timeoutErr
+}
@@ -0,0 +1,196 @@
+package zcodec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"dusnet/connect"
+	"dusnet/packet"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Cipher 会话加密算法
+type Cipher int
+
+const (
+	CipherAESGCM           Cipher = iota // AES-256-GCM，有AES硬件加速的设备使用
+	CipherChaCha20Poly1305               // ChaCha20-Poly1305，无AES硬件加速的MCU使用
+)
+
+// counterLen 加密报文体前的发送计数器长度
+const counterLen = 8
+
+// replayWindow 重放检测窗口，允许并发写入造成的少量乱序
+const replayWindow = 64
+
+var (
+	ErrReplay     = errors.New("replayed or too old packet counter")
+	ErrShortFrame = errors.New("encrypted body too short")
+)
+
+// plainRoutes 始终明文传输的路由，如认证握手路由
+var plainRoutes sync.Map
+
+// sessions 已建立加密会话的连接
+var sessions sync.Map
+
+// SetPlainRoutes 设置始终明文传输的路由id，会话建立前后均不加密，如认证握手路由
+func SetPlainRoutes(routeIDs ...uint32) {
+	for _, id := range routeIDs {
+		plainRoutes.Store(id, true)
+	}
+}
+
+// session 连接的加密会话，收发使用不同的密钥
+type session struct {
+	seal cipher.AEAD
+	open cipher.AEAD
+	lock sync.Mutex
+	sent uint64 // 最近发送的计数器
+	max  uint64 // 最近收到的最大计数器
+	seen uint64 // 以max为最高位的已收到计数器位图
+}
+
+// Establish 为连接建立加密会话，之后默认编解码器对该连接的报文体加解密；
+// 收发密钥由预共享设备密钥key以nonce(服务端随机数)、peerNonce(设备随机数)为盐经HKDF-SHA256派生，
+// 服务端server为true，设备端为false
+func Establish(conn connect.IConnection, c Cipher, key []byte, nonce []byte, peerNonce []byte, server bool) error {
+	salt := append(append([]byte{}, nonce...), peerNonce...)
+	up, err := deriveAEAD(c, key, salt, "dusnet uplink")
+	if err != nil {
+		return err
+	}
+	down, err := deriveAEAD(c, key, salt, "dusnet downlink")
+	if err != nil {
+		return err
+	}
+	s := &session{seal: up, open: down}
+	if server {
+		s.seal, s.open = down, up
+	}
+	sessions.Store(conn, s)
+	return nil
+}
+
+// Forget 连接释放时清除其加密会话
+func Forget(conn connect.IConnection) {
+	sessions.Delete(conn)
+}
+
+// Encrypted 连接是否已建立加密会话
+func Encrypted(conn connect.IConnection) bool {
+	_, ok := sessions.Load(conn)
+	return ok
+}
+
+func deriveAEAD(c Cipher, key []byte, salt []byte, info string) (cipher.AEAD, error) {
+	k := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(info)), k); err != nil {
+		return nil, err
+	}
+	if c == CipherChaCha20Poly1305 {
+		return chacha20poly1305.New(k)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sessionOf 返回需对pkt加解密的会话
+func sessionOf(conn connect.IConnection, pkt packet.IPacket) (*session, bool) {
+	if conn == nil {
+		return nil, false
+	}
+	if _, ok := plainRoutes.Load(pkt.GetID()); ok {
+		return nil, false
+	}
+	value, ok := sessions.Load(conn)
+	if !ok {
+		return nil, false
+	}
+	return value.(*session), true
+}
+
+// encrypt 加密后的报文体为 计数器(8) + 密文 + 认证标签，报文id及类型作为附加认证数据
+func (s *session) encrypt(pkt packet.IPacket) *packet.Packet {
+	s.lock.Lock()
+	s.sent++
+	counter := s.sent
+	s.lock.Unlock()
+	body := make([]byte, counterLen, counterLen+len(pkt.GetData())+s.seal.Overhead())
+	binary.BigEndian.PutUint64(body, counter)
+	body = s.seal.Seal(body, nonceOf(s.seal, counter), pkt.GetData(), additional(pkt))
+	out := &packet.Packet{}
+	out.ID = pkt.GetID()
+	out.Type = pkt.GetType()
+	out.Data = body
+	return out
+}
+
+func (s *session) decrypt(pkt *packet.Packet) error {
+	if len(pkt.Data) < counterLen+s.open.Overhead() {
+		return ErrShortFrame
+	}
+	counter := binary.BigEndian.Uint64(pkt.Data[:counterLen])
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.fresh(counter) {
+		return ErrReplay
+	}
+	data, err := s.open.Open(nil, nonceOf(s.open, counter), pkt.Data[counterLen:], additional(pkt))
+	if err != nil {
+		return err
+	}
+	s.accept(counter)
+	pkt.Data = data
+	pkt.Length = uint32(len(data))
+	return nil
+}
+
+// fresh 计数器是否未收到过且未落后于窗口
+func (s *session) fresh(counter uint64) bool {
+	if counter == 0 {
+		return false
+	}
+	if counter > s.max {
+		return true
+	}
+	diff := s.max - counter
+	return diff < replayWindow && s.seen&(1<<diff) == 0
+}
+
+func (s *session) accept(counter uint64) {
+	if counter > s.max {
+		shift := counter - s.max
+		if shift >= replayWindow {
+			s.seen = 0
+		} else {
+			s.seen <<= shift
+		}
+		s.seen |= 1
+		s.max = counter
+		return
+	}
+	s.seen |= 1 << (s.max - counter)
+}
+
+func nonceOf(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+func additional(pkt packet.IPacket) []byte {
+	ad := make([]byte, 6)
+	binary.BigEndian.PutUint32(ad, pkt.GetID())
+	binary.BigEndian.PutUint16(ad[4:], pkt.GetType())
+	return ad
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"strconv"
+	"time"
 )
 
 const (
@@ -21,11 +22,56 @@ type Icodec interface {
 	Decode(connect.IConnection) (packet.IPacket, error)
 }
 
+// IFramer 编解码器可选实现，事件驱动模式下由reactor非阻塞读取套接字，据此判断缓冲区中是否已有完整报文
+type IFramer interface {
+	FrameLen(buf []byte) (int, bool) // 返回buf中第一个报文的总长度及buf是否已包含完整报文，报文头不完整时长度为0
+}
+
+// IFrameLimiter 编解码器可选实现，限制单个报文的最大长度，避免按报文头中的长度分配过大的缓冲区
+type IFrameLimiter interface {
+	SetMaxFrame(max int) // 单个报文含报文头的最大长度，超过时Decode返回ErrFrameTooLarge，为0时不限制
+}
+
+// IConnEncoder 编解码器可选实现，按目标连接编码，如对已建立加密会话的连接加密报文体
+type IConnEncoder interface {
+	EncodeTo(conn connect.IConnection, pkt packet.IPacket) ([]byte, error)
+}
+
+// EncodeFor 编码发往conn的报文，c实现IConnEncoder时按连接编码
+func EncodeFor(c Icodec, conn connect.IConnection, pkt packet.IPacket) ([]byte, error) {
+	if e, ok := c.(IConnEncoder); ok {
+		return e.EncodeTo(conn, pkt)
+	}
+	return c.Encode(pkt)
+}
+
+// headLen 报文头长度 id(4) + type(2) + length(4)
+const headLen = 10
+
+// DefaultMaxFrame 默认编解码器单个报文的最大长度
+const DefaultMaxFrame = 16 << 20
+
+var ErrFrameTooLarge = errors.New("packet length exceeds max frame")
+
 func Default() Icodec {
-	return &codec{}
+	return &codec{maxFrame: DefaultMaxFrame}
+}
+
+// NewWithTimeouts 返回带读取超时保护的默认编解码器：报文头首字节到达后header内需收全报文头，
+// 报文头收全后body内需收全报文体，超时返回ErrHeaderTimeout/ErrBodyTimeout，连接随之释放；为0时不限制
+// 连接需实现connect.IDeadlineConnection，否则不限制
+func NewWithTimeouts(header time.Duration, body time.Duration) Icodec {
+	return &codec{headerTimeout: header, bodyTimeout: body, maxFrame: DefaultMaxFrame}
 }
 
 type codec struct {
+	headerTimeout time.Duration // 报文头读取超时
+	bodyTimeout   time.Duration // 报文体读取超时
+	maxFrame      int           // 单个报文的最大长度，为0时不限制
+}
+
+func (c *codec) SetMaxFrame(max int) {
+	c.maxFrame = max
 }
 
 func (c *codec) Encode(p packet.IPacket) ([]byte, error) {
@@ -57,11 +103,35 @@ func (c *codec) Encode(p packet.IPacket) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
+// EncodeTo 连接已建立加密会话时加密报文体
+func (c *codec) EncodeTo(conn connect.IConnection, p packet.IPacket) ([]byte, error) {
+	if s, ok := sessionOf(conn, p); ok {
+		return c.Encode(s.encrypt(p))
+	}
+	return c.Encode(p)
+}
+
+func (c *codec) FrameLen(buf []byte) (int, bool) {
+	if len(buf) < headLen {
+		return 0, false
+	}
+	total := headLen + int(binary.BigEndian.Uint32(buf[6:headLen]))
+	return total, len(buf) >= total
+}
+
 func (c *codec) Decode(conn connect.IConnection) (packet.IPacket, error) {
 	pkt := &packet.Packet{}
+	dc, timed := conn.(connect.IDeadlineConnection)
+	timed = timed && (c.headerTimeout > 0 || c.bodyTimeout > 0)
 	// decode head/id
 	idBuf := make([]byte, 4)
-	err := conn.Read(idBuf)
+	var err error
+	if timed {
+		// 首字节到达前为空闲，由空闲检测处理；之后报文头需在超时内收全
+		err = c.readHead(conn, dc, idBuf)
+	} else {
+		err = conn.Read(idBuf)
+	}
 	if err != nil {
 		logger.Error("conn.Read head/id error,error:%+v", err)
 		return pkt, err
@@ -77,6 +147,9 @@ func (c *codec) Decode(conn connect.IConnection) (packet.IPacket, error) {
 
 	typeBuf := make([]byte, 2)
 	err = conn.Read(typeBuf)
+	if timed {
+		err = c.headerErr(conn, err)
+	}
 	if err != nil {
 		logger.Error("conn.Read head/type error,error:%+v", err)
 		return pkt, err
@@ -93,6 +166,9 @@ func (c *codec) Decode(conn connect.IConnection) (packet.IPacket, error) {
 	// decode head/length
 	lengthBuf := make([]byte, 4)
 	err = conn.Read(lengthBuf)
+	if timed {
+		err = c.headerErr(conn, err)
+	}
 	if err != nil {
 		logger.Error("conn.Read head/length error,error:%+v", err)
 		return pkt, err
@@ -102,14 +178,28 @@ func (c *codec) Decode(conn connect.IConnection) (packet.IPacket, error) {
 		logger.Error("binary.Write head/length to pkt error,error:%+v", err)
 		return pkt, err
 	}
+	if c.maxFrame > 0 && uint64(pkt.Length) > uint64(c.maxFrame-headLen) {
+		logger.Warn("connection[id=%d,raddr:%s:%d] packet length %d exceeds max frame %d", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), pkt.Length, c.maxFrame)
+		return pkt, ErrFrameTooLarge
+	}
 
 	// decode head body/data
 	dataBuf := make([]byte, pkt.Length)
-	err = conn.Read(dataBuf)
+	if timed {
+		err = c.readBody(conn, dc, dataBuf)
+	} else {
+		err = conn.Read(dataBuf)
+	}
 	if err != nil {
 		logger.Error("conn.Read body/data error,error:%+v", err)
 		return pkt, err
 	}
 	pkt.Data = dataBuf
+	if s, ok := sessionOf(conn, pkt); ok {
+		if err := s.decrypt(pkt); err != nil {
+			logger.Error("decrypt body/data error,error:%+v", err)
+			return pkt, err
+		}
+	}
 	return pkt, nil
 }
@@ -0,0 +1,78 @@
+package connect
+
+import (
+	"net"
+	"net/netip"
+)
+
+// AddrPort 将net.Addr转换为netip.AddrPort，IPv4映射的IPv6地址还原为IPv4，无法解析时返回零值
+func AddrPort(addr net.Addr) netip.AddrPort {
+	if addr == nil {
+		return netip.AddrPort{}
+	}
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return unmap(a.AddrPort())
+	case *net.UDPAddr:
+		return unmap(a.AddrPort())
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return unmap(ap)
+}
+
+func unmap(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// RemoteAddrPortOf 返回连接的远程地址，连接未实现IAddrConnection时由远程host及端口还原
+func RemoteAddrPortOf(conn IConnection) netip.AddrPort {
+	if a, ok := conn.(IAddrConnection); ok {
+		return a.RemoteAddrPort()
+	}
+	addr, err := netip.ParseAddr(conn.GetRemoteHost())
+	if err != nil {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(addr.Unmap(), uint16(conn.GetRemotePort()))
+}
+
+// LocalAddrOf 返回连接的本地地址，连接未实现IAddrConnection时由本地host及端口还原，无法还原时为nil
+func LocalAddrOf(conn IConnection) net.Addr {
+	if a, ok := conn.(IAddrConnection); ok {
+		return a.LocalAddr()
+	}
+	addr, err := netip.ParseAddr(conn.GetLocalHost())
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), uint16(conn.GetLocalPort())))
+}
+
+// RemoteAddrOf 返回连接的远程地址，连接未实现IAddrConnection时由远程host及端口还原，无法还原时为nil
+func RemoteAddrOf(conn IConnection) net.Addr {
+	if a, ok := conn.(IAddrConnection); ok {
+		return a.RemoteAddr()
+	}
+	ap := RemoteAddrPortOf(conn)
+	if !ap.IsValid() {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+// HostOf 返回地址的host，IPv6地址不带方括号及端口，如2001:db8::1
+func HostOf(addr net.Addr) string {
+	ap := AddrPort(addr)
+	if !ap.IsValid() {
+		return ""
+	}
+	return ap.Addr().String()
+}
+
+// PortOf 返回地址的端口
+func PortOf(addr net.Addr) int {
+	return int(AddrPort(addr).Port())
+}
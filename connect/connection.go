@@ -1,11 +1,15 @@
 package connect
 
 import (
+	"crypto/tls"
 	"dusnet/logger"
+	"errors"
+	"io"
 	"net"
-	"strconv"
-	"strings"
+	"net/netip"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -19,10 +23,24 @@ type IConnection interface {
 	SetID(uint64)          // 设置连接id
 	GetLocalHost() string  // 获取本地host
 	GetLocalPort() int     // 获取本地端口
-	GetRemoteHost() string // 获取远程host
+	GetRemoteHost() string // 获取远程host，IPv6地址不带方括号
 	GetRemotePort() int    // 获取远程端口
 }
 
+// IAddrConnection 可获取完整地址的连接，未实现时由host及端口还原地址
+type IAddrConnection interface {
+	LocalAddr() net.Addr            // 本地地址
+	RemoteAddr() net.Addr           // 远程地址，经PROXY协议转发时为真实客户端地址
+	RemoteAddrPort() netip.AddrPort // 远程地址，IPv4映射的IPv6地址还原为IPv4
+}
+
+// IActiveConnection 记录活跃时间的连接，嵌入Activity即可实现，空闲检测只对实现此接口的连接生效
+type IActiveConnection interface {
+	Renew()                   // 续租，收到报文时刷新最近活跃时间
+	GetConnTime() time.Time   // 获取建立连接时间
+	GetUpdateTime() time.Time // 获取最近活跃时间
+}
+
 type IConnectionMgr interface {
 	GetConnByID(id uint64) IConnection          // 根据连接id获取连接
 	All() []IConnection                         // 获取所有连接
@@ -35,22 +53,83 @@ type IConnectionMgr interface {
 	GenConnID() uint64        // 生成连接id
 }
 
+// IGroupMgr 支持连接分组的连接管理器，分组发送及按分组的访问控制依赖此接口
+type IGroupMgr interface {
+	Join(group string, id uint64) error // 将连接加入分组，连接移除时自动退出所有分组
+	Leave(group string, id uint64)      // 将连接移出分组
+	Members(group string) []IConnection // 获取分组内的连接
+	Groups(id uint64) []string          // 获取连接加入的分组
+}
+
+// IDeviceMgr 支持设备绑定的连接管理器，设备会话上限、按设备下发及按设备的限流、访问控制依赖此接口
+type IDeviceMgr interface {
+	SetDeviceConfig(conf DeviceConfig)           // 设置设备绑定配置
+	BindDevice(device string, id uint64) error   // 认证后将连接绑定到设备，超过会话上限时按配置踢掉旧连接或返回ErrDuplicateDevice
+	UnbindDevice(id uint64)                      // 解绑连接的设备，连接移除时自动解绑
+	GetConnByDevice(device string) []IConnection // 根据设备id获取连接，按绑定先后排序
+	GetDevice(id uint64) string                  // 获取连接绑定的设备id，未绑定时为空
+}
+
+// Renew 连接实现IActiveConnection时续租
+func Renew(conn IConnection) {
+	if a, ok := conn.(IActiveConnection); ok {
+		a.Renew()
+	}
+}
+
+// DeviceOf 连接管理器实现IDeviceMgr时返回连接绑定的设备id，否则为空
+func DeviceOf(mgr IConnectionMgr, id uint64) string {
+	if d, ok := mgr.(IDeviceMgr); ok {
+		return d.GetDevice(id)
+	}
+	return ""
+}
+
 type mConnection struct {
-	id         uint64
-	conn       *net.TCPConn
-	alive      bool
-	connTime   time.Time
-	updateTime time.Time
+	Activity
+	id      uint64
+	conn    net.Conn
+	alive   bool
+	feeding bool        // 事件驱动模式，套接字由reactor读取，Read从投递的报文中读取
+	fed     []byte      // reactor投递的完整报文
+	queue   *writeQueue // 异步写队列，为空时同步写入
+	wlock   sync.Mutex  // 同步写入时串行化并发写入方，保证报文不交错
+}
+
+// Activity 连接活跃时间，IConnection的实现嵌入后即实现续租相关方法
+type Activity struct {
+	connTime   time.Time // 建立连接时间
+	updateTime int64     // 最近活跃时间，unix纳秒，读取报文与空闲检测并发访问
+}
+
+// NewActivity 以当前时间作为建立连接时间及最近活跃时间
+func NewActivity() Activity {
+	now := time.Now()
+	return Activity{connTime: now, updateTime: now.UnixNano()}
+}
+
+func (a *Activity) Renew() {
+	atomic.StoreInt64(&a.updateTime, time.Now().UnixNano())
+}
+
+func (a *Activity) GetConnTime() time.Time {
+	return a.connTime
+}
+
+func (a *Activity) GetUpdateTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&a.updateTime))
 }
 
 type connectionMgr struct {
 	GlobalConnID uint64   // 全局连接id计数
 	pool         sync.Map // 连接池
+	groups       groups   // 连接分组
+	devices      devices  // 设备绑定
 }
 
+// GenConnID 接受协程、mux及中继链路等并发分配连接id
 func (c *connectionMgr) GenConnID() uint64 {
-	c.GlobalConnID++
-	return c.GlobalConnID
+	return atomic.AddUint64(&c.GlobalConnID, 1)
 }
 
 func DefaultConnMgr() IConnectionMgr {
@@ -66,17 +145,27 @@ func (m *mConnection) SetAlive(alive bool) {
 }
 
 func (m *mConnection) GetRemotePort() int {
-	port, _ := strconv.Atoi(strings.Split(m.conn.RemoteAddr().String(), ":")[1])
-	return port
+	return PortOf(m.conn.RemoteAddr())
 }
 
 func (m *mConnection) GetLocalHost() string {
-	return strings.Split(m.conn.LocalAddr().String(), ":")[0]
+	return HostOf(m.conn.LocalAddr())
 }
 
 func (m *mConnection) GetLocalPort() int {
-	port, _ := strconv.Atoi(strings.Split(m.conn.LocalAddr().String(), ":")[1])
-	return port
+	return PortOf(m.conn.LocalAddr())
+}
+
+func (m *mConnection) LocalAddr() net.Addr {
+	return m.conn.LocalAddr()
+}
+
+func (m *mConnection) RemoteAddr() net.Addr {
+	return m.conn.RemoteAddr()
+}
+
+func (m *mConnection) RemoteAddrPort() netip.AddrPort {
+	return AddrPort(m.conn.RemoteAddr())
 }
 
 func (m *mConnection) GetID() uint64 {
@@ -84,39 +173,121 @@ func (m *mConnection) GetID() uint64 {
 }
 
 func (m *mConnection) Read(bytes []byte) error {
-	_, err := m.conn.Read(bytes)
+	if m.feeding {
+		if len(m.fed) < len(bytes) {
+			return io.ErrUnexpectedEOF
+		}
+		copy(bytes, m.fed)
+		m.fed = m.fed[len(bytes):]
+		return nil
+	}
+	// 编解码器按字段长度读取，必须读满，否则一个包会被拆开解析
+	_, err := io.ReadFull(m.conn, bytes)
 	return err
 }
 
 func (m *mConnection) Write(bytes []byte) error {
+	if m.queue != nil {
+		return m.queue.push(bytes)
+	}
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
 	_, err := m.conn.Write(bytes)
 	return err
 }
 
+func (m *mConnection) EnableWriteQueue(conf WriteQueueConfig) {
+	if m.queue == nil {
+		m.queue = newWriteQueue(m, m.conn, conf)
+	}
+}
+
+func (m *mConnection) QueueStats() QueueStats {
+	if m.queue == nil {
+		return QueueStats{}
+	}
+	return m.queue.stats()
+}
+
 func (m *mConnection) GetRemoteHost() string {
-	// host:port 仅截取host
-	return strings.Split(m.conn.RemoteAddr().String(), ":")[0]
+	return HostOf(m.conn.RemoteAddr())
 }
 
-func New(l *net.TCPListener, mgr IConnectionMgr) IConnection {
-	conn, err := l.AcceptTCP()
+// New 从监听器接受一个连接并加入连接管理器，TLS监听器接受的连接实现ITLSConnection
+func New(l net.Listener, mgr IConnectionMgr) IConnection {
+	conn, err := l.Accept()
 	if err != nil {
-		logger.Error("listener.AcceptTCP error,error:%+v", err)
+		logger.Error("listener.Accept error,error:%+v", err)
 		return nil
 	}
 	var idLock sync.RWMutex
 	idLock.RLock()
 	defer idLock.RUnlock()
 	c := &mConnection{
-		id:    mgr.GenConnID(),
-		conn:  conn,
-		alive: true,
+		Activity: NewActivity(),
+		id:       mgr.GenConnID(),
+		conn:     conn,
+		alive:    true,
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		t := &tlsConnection{mConnection: c, tls: tc}
+		mgr.AddConn(t)
+		return t
 	}
 	mgr.AddConn(c)
 	return c
 }
 
+// Feed 事件驱动模式下投递reactor读取到的完整报文，之后的Read均从投递的数据中读取
+func (m *mConnection) Feed(frame []byte) {
+	m.feeding = true
+	m.fed = frame
+}
+
+// SyscallConn 返回底层套接字，供reactor注册事件及非阻塞读取
+func (m *mConnection) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := m.conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("connection does not expose raw socket")
+	}
+	return sc.SyscallConn()
+}
+
+// Dial 主动连接其他dusnet节点，返回的连接不归属任何连接管理器
+func Dial(network string, address string) (IConnection, error) {
+	raddr, err := net.ResolveTCPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTCP(network, nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &mConnection{
+		Activity: NewActivity(),
+		conn:     conn,
+		alive:    true,
+	}, nil
+}
+
+// DialTimeout 同Dial，连接在timeout内未建立时返回错误
+func DialTimeout(network string, address string, timeout time.Duration) (IConnection, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &mConnection{
+		Activity: NewActivity(),
+		conn:     conn,
+		alive:    true,
+	}, nil
+}
+
+// Close 启用写队列时先写出已入队的报文，最长等待写超时
 func (m *mConnection) Close() error {
+	if m.queue != nil {
+		m.queue.close()
+	}
 	return m.conn.Close()
 }
 
@@ -185,17 +356,20 @@ func (c *connectionMgr) RemoveConnByID(id uint64) error {
 	return doRemoveConn(c, conn)
 }
 
+// doRemoveConn 关闭出错时仍移除连接并清理分组及设备绑定，避免残留已关闭的连接
 func doRemoveConn(c *connectionMgr, conn IConnection) error {
+	var err error
 	if conn != nil && conn.Alive() {
 		conn.SetAlive(false)
-		if err := conn.Close(); err != nil {
+		if err = conn.Close(); err != nil {
 			// close err
 			logger.Error("conn.Close error,error:%+v", err)
-			return err
 		}
 	}
 	c.pool.Delete(conn.GetID())
-	return nil
+	c.groups.leaveAll(conn.GetID())
+	c.unbindDevice(conn)
+	return err
 }
 
 func (c *connectionMgr) RemoveConnBySrcHost(host string) error {
@@ -214,3 +388,16 @@ func (c *connectionMgr) RemoveConnBySrcHost(host string) error {
 	}
 	return doRemoveConn(c, conn)
 }
+
+// IDeadlineConnection 支持读取截止时间的连接，编解码器据此限制报文头及报文体的读取时间
+type IDeadlineConnection interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// SetReadDeadline 事件驱动模式下报文已完整投递，无需截止时间
+func (m *mConnection) SetReadDeadline(t time.Time) error {
+	if m.feeding {
+		return nil
+	}
+	return m.conn.SetReadDeadline(t)
+}
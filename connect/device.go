@@ -0,0 +1,187 @@
+package connect
+
+import (
+	"dusnet/logger"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DuplicatePolicy 同一设备的会话数超过上限时的处理策略
+type DuplicatePolicy int
+
+const (
+	DuplicateKickOld   DuplicatePolicy = iota // 断开最早的会话，接受新会话
+	DuplicateRejectNew                        // 拒绝新会话
+)
+
+var ErrDuplicateDevice = errors.New("device already has max sessions")
+
+// DeviceConfig 设备绑定配置
+type DeviceConfig struct {
+	MaxSessions int                                   // 同一设备允许的会话数，默认1
+	Policy      DuplicatePolicy                       // 超过MaxSessions时的策略
+	OnBind      func(device string, conn IConnection) // 连接绑定设备后回调，不可阻塞
+	OnUnbind    func(device string, conn IConnection) // 连接解绑设备后回调，含连接移除及被踢下线，不可阻塞
+	OnKick      func(conn IConnection)                // 释放被踢下线的连接，为空时直接从连接管理器移除；server启动时设置为自身的释放流程
+}
+
+// devices 设备id与连接的绑定，连接认证后绑定到设备，一个连接只能绑定一个设备
+type devices struct {
+	lock     sync.Mutex
+	conf     DeviceConfig
+	sessions map[string][]uint64 // 设备id -> 连接id，按绑定先后排序
+	owners   map[uint64]string   // 连接id -> 设备id
+}
+
+// bind 返回因超过会话上限被踢下线的连接id及原先绑定的设备
+func (d *devices) bind(device string, id uint64) (kicked []uint64, previous string, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.sessions == nil {
+		d.sessions = make(map[string][]uint64)
+		d.owners = make(map[uint64]string)
+	}
+	previous, bound := d.owners[id]
+	if bound && previous == device {
+		return nil, "", nil
+	}
+	max := d.conf.MaxSessions
+	if max <= 0 {
+		max = 1
+	}
+	ids := d.sessions[device]
+	if len(ids) >= max {
+		if d.conf.Policy == DuplicateRejectNew {
+			return nil, "", ErrDuplicateDevice
+		}
+		kicked = append(kicked, ids[:len(ids)-max+1]...)
+		for _, k := range kicked {
+			d.unbindLocked(k)
+		}
+	}
+	if bound {
+		d.unbindLocked(id)
+	}
+	d.sessions[device] = append(d.sessions[device], id)
+	d.owners[id] = device
+	return kicked, previous, nil
+}
+
+func (d *devices) unbind(id uint64) (string, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.unbindLocked(id)
+}
+
+func (d *devices) unbindLocked(id uint64) (string, bool) {
+	device, ok := d.owners[id]
+	if !ok {
+		return "", false
+	}
+	delete(d.owners, id)
+	ids := d.sessions[device]
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(d.sessions, device)
+	} else {
+		d.sessions[device] = ids
+	}
+	return device, true
+}
+
+func (d *devices) ids(device string) []uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]uint64(nil), d.sessions[device]...)
+}
+
+func (d *devices) owner(id uint64) string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.owners[id]
+}
+
+func (d *devices) config() DeviceConfig {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.conf
+}
+
+func (c *connectionMgr) SetDeviceConfig(conf DeviceConfig) {
+	c.devices.lock.Lock()
+	defer c.devices.lock.Unlock()
+	c.devices.conf = conf
+}
+
+func (c *connectionMgr) BindDevice(device string, id uint64) error {
+	if device == "" {
+		return errors.New("device id is empty")
+	}
+	conn := c.GetConnByID(id)
+	if conn == nil {
+		return errors.New(fmt.Sprintf("connection[id=%d] not exist", id))
+	}
+	kicked, previous, err := c.devices.bind(device, id)
+	if err != nil {
+		logger.Warn("device[%s] reject new connection[id=%d],error:%+v", device, id, err)
+		return err
+	}
+	conf := c.devices.config()
+	if previous != "" && conf.OnUnbind != nil {
+		conf.OnUnbind(previous, conn)
+	}
+	for _, k := range kicked {
+		if old := c.GetConnByID(k); old != nil {
+			logger.Warn("device[%s] reconnected with connection[id=%d],kick old connection[id=%d]", device, id, k)
+			if conf.OnUnbind != nil {
+				conf.OnUnbind(device, old)
+			}
+			if conf.OnKick != nil {
+				conf.OnKick(old)
+			} else {
+				_ = doRemoveConn(c, old)
+			}
+		}
+	}
+	if conf.OnBind != nil {
+		conf.OnBind(device, conn)
+	}
+	return nil
+}
+
+func (c *connectionMgr) UnbindDevice(id uint64) {
+	if conn := c.GetConnByID(id); conn != nil {
+		c.unbindDevice(conn)
+	}
+}
+
+// unbindDevice 解绑连接绑定的设备，连接移除时调用
+func (c *connectionMgr) unbindDevice(conn IConnection) {
+	device, ok := c.devices.unbind(conn.GetID())
+	if !ok {
+		return
+	}
+	if conf := c.devices.config(); conf.OnUnbind != nil {
+		conf.OnUnbind(device, conn)
+	}
+}
+
+func (c *connectionMgr) GetConnByDevice(device string) []IConnection {
+	var conns []IConnection
+	for _, id := range c.devices.ids(device) {
+		if conn := c.GetConnByID(id); conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+func (c *connectionMgr) GetDevice(id uint64) string {
+	return c.devices.owner(id)
+}
@@ -0,0 +1,110 @@
+package connect
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// groups 连接分组，分组以名称区分(如"line-3 buses"、"greenhouse-7")，一个连接可加入多个分组
+type groups struct {
+	lock    sync.RWMutex
+	members map[string]map[uint64]struct{} // 分组 -> 连接id
+	joined  map[uint64]map[string]struct{} // 连接id -> 分组
+}
+
+func (g *groups) join(group string, id uint64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.members == nil {
+		g.members = make(map[string]map[uint64]struct{})
+		g.joined = make(map[uint64]map[string]struct{})
+	}
+	if g.members[group] == nil {
+		g.members[group] = make(map[uint64]struct{})
+	}
+	g.members[group][id] = struct{}{}
+	if g.joined[id] == nil {
+		g.joined[id] = make(map[string]struct{})
+	}
+	g.joined[id][group] = struct{}{}
+}
+
+func (g *groups) leave(group string, id uint64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.leaveLocked(group, id)
+}
+
+func (g *groups) leaveLocked(group string, id uint64) {
+	if ids, ok := g.members[group]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(g.members, group)
+		}
+	}
+	if names, ok := g.joined[id]; ok {
+		delete(names, group)
+		if len(names) == 0 {
+			delete(g.joined, id)
+		}
+	}
+}
+
+// leaveAll 连接断开时退出其加入的所有分组
+func (g *groups) leaveAll(id uint64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for group := range g.joined[id] {
+		g.leaveLocked(group, id)
+	}
+}
+
+func (g *groups) ids(group string) []uint64 {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	ids := make([]uint64, 0, len(g.members[group]))
+	for id := range g.members[group] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (g *groups) names(id uint64) []string {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	names := make([]string, 0, len(g.joined[id]))
+	for group := range g.joined[id] {
+		names = append(names, group)
+	}
+	return names
+}
+
+func (c *connectionMgr) Join(group string, id uint64) error {
+	if group == "" {
+		return errors.New("group name is empty")
+	}
+	if _, ok := c.pool.Load(id); !ok {
+		return errors.New(fmt.Sprintf("connection[id=%d] not exist", id))
+	}
+	c.groups.join(group, id)
+	return nil
+}
+
+func (c *connectionMgr) Leave(group string, id uint64) {
+	c.groups.leave(group, id)
+}
+
+func (c *connectionMgr) Members(group string) []IConnection {
+	var conns []IConnection
+	for _, id := range c.groups.ids(group) {
+		if conn := c.GetConnByID(id); conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+func (c *connectionMgr) Groups(id uint64) []string {
+	return c.groups.names(id)
+}
@@ -0,0 +1,218 @@
+package connect
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWheelTick   = 100 * time.Millisecond
+	defaultWheelSize   = 512
+	defaultWheelLevels = 4
+)
+
+var (
+	defaultWheel     *TimingWheel
+	defaultWheelOnce sync.Once
+)
+
+// DefaultTimingWheel 返回进程内共享的时间轮，精度100ms，首次调用时启动
+// 连接空闲检测、请求超时、握手超时等大量连接级超时均应使用时间轮而非为每个连接创建time.Timer
+func DefaultTimingWheel() *TimingWheel {
+	defaultWheelOnce.Do(func() {
+		defaultWheel = NewTimingWheel(defaultWheelTick, defaultWheelSize)
+		defaultWheel.Start()
+	})
+	return defaultWheel
+}
+
+// TimingWheel 分层时间轮，每层size个槽，第0层每槽tick，第n层每槽tick*size^n，
+// 低层转满一圈时将上一层当前槽的定时器下放到低层，添加、停止定时器均为O(1)
+type TimingWheel struct {
+	tick    time.Duration
+	size    int64
+	lock    sync.Mutex
+	levels  [defaultWheelLevels][]*list.List
+	current int64 // 已推进的tick数
+	start   time.Time
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// Timer 时间轮定时器
+type Timer struct {
+	wheel  *TimingWheel
+	expire int64 // 到期的tick数
+	task   func()
+	bucket *list.List
+	elem   *list.Element
+}
+
+// NewTimingWheel 返回时间轮，tick为精度，size为每层槽数，需调用Start后定时器才会触发
+func NewTimingWheel(tick time.Duration, size int) *TimingWheel {
+	if tick <= 0 {
+		tick = defaultWheelTick
+	}
+	if size <= 1 {
+		size = defaultWheelSize
+	}
+	tw := &TimingWheel{
+		tick: tick,
+		size: int64(size),
+		stop: make(chan struct{}),
+	}
+	for i := range tw.levels {
+		tw.levels[i] = make([]*list.List, size)
+		for j := range tw.levels[i] {
+			tw.levels[i][j] = list.New()
+		}
+	}
+	return tw
+}
+
+// Start 启动时间轮
+func (tw *TimingWheel) Start() {
+	tw.lock.Lock()
+	tw.start = time.Now()
+	tw.lock.Unlock()
+	go tw.run()
+}
+
+// Stop 停止时间轮，未触发的定时器不再触发
+func (tw *TimingWheel) Stop() {
+	tw.once.Do(func() {
+		close(tw.stop)
+	})
+}
+
+// AfterFunc d之后在时间轮的驱动协程中执行task，task应尽快返回，耗时操作需自行另起协程
+func (tw *TimingWheel) AfterFunc(d time.Duration, task func()) *Timer {
+	t := &Timer{wheel: tw, task: task}
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	tw.schedule(t, d)
+	return t
+}
+
+// Stop 停止定时器，返回定时器是否在触发前被停止
+func (t *Timer) Stop() bool {
+	tw := t.wheel
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	return t.remove()
+}
+
+// Reset 停止定时器并重新在d之后触发，返回定时器是否在触发前被停止
+func (t *Timer) Reset(d time.Duration) bool {
+	tw := t.wheel
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	stopped := t.remove()
+	tw.schedule(t, d)
+	return stopped
+}
+
+// remove 从所在槽移除，调用方需持有锁
+func (t *Timer) remove() bool {
+	if t.bucket == nil {
+		return false
+	}
+	t.bucket.Remove(t.elem)
+	t.bucket = nil
+	t.elem = nil
+	return true
+}
+
+// schedule 按延迟计算到期tick并放入对应槽，调用方需持有锁
+func (tw *TimingWheel) schedule(t *Timer, d time.Duration) {
+	ticks := int64((d + tw.tick - 1) / tw.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	t.expire = tw.current + ticks
+	tw.place(t)
+}
+
+// place 按到期tick与当前tick的距离选择层级，调用方需持有锁
+func (tw *TimingWheel) place(t *Timer) {
+	distance := t.expire - tw.current
+	span := tw.size
+	level := 0
+	for level < len(tw.levels)-1 && distance >= span {
+		span *= tw.size
+		level++
+	}
+	unit := span / tw.size
+	if distance >= span {
+		// 超出最大范围，放入顶层最远的槽，转到时再重新计算
+		t.bucket = tw.levels[level][(tw.current/unit+tw.size-1)%tw.size]
+	} else {
+		t.bucket = tw.levels[level][(t.expire/unit)%tw.size]
+	}
+	t.elem = t.bucket.PushBack(t)
+}
+
+func (tw *TimingWheel) run() {
+	ticker := time.NewTicker(tw.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tw.stop:
+			return
+		case now := <-ticker.C:
+			// 按实际流逝时间推进，驱动协程被延迟调度时也不会少推进
+			tw.lock.Lock()
+			target := int64(now.Sub(tw.start) / tw.tick)
+			tw.lock.Unlock()
+			for {
+				tasks, more := tw.advance(target)
+				for _, task := range tasks {
+					task()
+				}
+				if !more {
+					break
+				}
+			}
+		}
+	}
+}
+
+// advance 推进一个tick，返回到期的任务及是否仍未推进到target
+func (tw *TimingWheel) advance(target int64) ([]func(), bool) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.current >= target {
+		return nil, false
+	}
+	tw.current++
+	// 低层转满一圈，逐层下放上一层当前槽
+	unit := int64(1)
+	for level := 1; level < len(tw.levels); level++ {
+		unit *= tw.size
+		if tw.current%unit != 0 {
+			break
+		}
+		bucket := tw.levels[level][(tw.current/unit)%tw.size]
+		for e := bucket.Front(); e != nil; {
+			next := e.Next()
+			t := bucket.Remove(e).(*Timer)
+			tw.place(t)
+			e = next
+		}
+	}
+	bucket := tw.levels[0][tw.current%tw.size]
+	var tasks []func()
+	for e := bucket.Front(); e != nil; {
+		next := e.Next()
+		t := e.Value.(*Timer)
+		if t.expire <= tw.current {
+			bucket.Remove(e)
+			t.bucket = nil
+			t.elem = nil
+			tasks = append(tasks, t.task)
+		}
+		e = next
+	}
+	return tasks, tw.current < target
+}
@@ -0,0 +1,194 @@
+package connect
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// drive 不启动驱动协程，逐tick推进到target并执行到期任务
+func drive(tw *TimingWheel, target int64) {
+	for {
+		tasks, more := tw.advance(target)
+		for _, task := range tasks {
+			task()
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// firedAt 记录定时器在第几个tick触发，未触发时为0
+func firedAt(tw *TimingWheel, ticks int64) *int64 {
+	at := new(int64)
+	tw.AfterFunc(time.Duration(ticks)*tw.tick, func() {
+		*at = tw.current
+	})
+	return at
+}
+
+func TestTimingWheelFire(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 4)
+	at := firedAt(tw, 3)
+	drive(tw, 2)
+	if *at != 0 {
+		t.Fatalf("timer fired early at tick %d", *at)
+	}
+	drive(tw, 3)
+	if *at != 3 {
+		t.Fatalf("timer fired at tick %d,want 3", *at)
+	}
+	// 不足一个tick的延迟按一个tick计
+	at = firedAt(tw, 0)
+	drive(tw, 4)
+	if *at != 4 {
+		t.Fatalf("zero delay timer fired at tick %d,want 4", *at)
+	}
+}
+
+func TestTimingWheelCascade(t *testing.T) {
+	// 每层4个槽，各层范围依次为4、16、64、256个tick
+	tw := NewTimingWheel(time.Millisecond, 4)
+	delays := []int64{1, 4, 5, 15, 16, 17, 63, 64, 65, 200, 255}
+	ats := make([]*int64, len(delays))
+	for i, d := range delays {
+		ats[i] = firedAt(tw, d)
+	}
+	drive(tw, 300)
+	for i, d := range delays {
+		if *ats[i] != d {
+			t.Fatalf("timer with delay %d fired at tick %d", d, *ats[i])
+		}
+	}
+	// 推进过程中添加的定时器同样按当前tick计算
+	drive(tw, 301)
+	at := firedAt(tw, 70)
+	drive(tw, 400)
+	if *at != 371 {
+		t.Fatalf("timer added at tick 301 fired at tick %d,want 371", *at)
+	}
+}
+
+func TestTimingWheelOverflow(t *testing.T) {
+	// 超出顶层范围(256个tick)的定时器放入顶层最远的槽，转到时重新计算
+	tw := NewTimingWheel(time.Millisecond, 4)
+	delays := []int64{256, 257, 1000, 5000}
+	ats := make([]*int64, len(delays))
+	for i, d := range delays {
+		ats[i] = firedAt(tw, d)
+	}
+	drive(tw, 999)
+	if *ats[2] != 0 || *ats[3] != 0 {
+		t.Fatalf("overflow timer fired early at tick %d,%d", *ats[2], *ats[3])
+	}
+	drive(tw, 6000)
+	for i, d := range delays {
+		if *ats[i] != d {
+			t.Fatalf("timer with delay %d fired at tick %d", d, *ats[i])
+		}
+	}
+}
+
+func TestTimingWheelStop(t *testing.T) {
+	tw := NewTimingWheel(time.Millisecond, 4)
+	fired := 0
+	stopped := tw.AfterFunc(100*time.Millisecond, func() { fired++ })
+	done := tw.AfterFunc(2*time.Millisecond, func() { fired++ })
+	if !stopped.Stop() {
+		t.Fatalf("stop pending timer returned false")
+	}
+	if stopped.Stop() {
+		t.Fatalf("stop twice returned true")
+	}
+	drive(tw, 200)
+	if fired != 1 {
+		t.Fatalf("fired %d timers,want 1", fired)
+	}
+	// 已触发的定时器停止时返回false
+	if done.Stop() {
+		t.Fatalf("stop after fire returned true")
+	}
+	// 触发后Reset重新计时
+	if done.Reset(3 * time.Millisecond) {
+		t.Fatalf("reset after fire returned true")
+	}
+	drive(tw, 203)
+	if fired != 2 {
+		t.Fatalf("reset timer not fired")
+	}
+}
+
+func TestTimingWheelStart(t *testing.T) {
+	tw := NewTimingWheel(5*time.Millisecond, 8)
+	tw.Start()
+	defer tw.Stop()
+	fired := make(chan time.Time, 1)
+	begin := time.Now()
+	tw.AfterFunc(50*time.Millisecond, func() { fired <- time.Now() })
+	select {
+	case at := <-fired:
+		if elapsed := at.Sub(begin); elapsed < 45*time.Millisecond {
+			t.Fatalf("timer fired after %s,want about 50ms", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timer not fired")
+	}
+	// 停止后不再触发
+	var count int32
+	tw.AfterFunc(20*time.Millisecond, func() { atomic.AddInt32(&count, 1) })
+	tw.Stop()
+	time.Sleep(60 * time.Millisecond)
+	if atomic.LoadInt32(&count) != 0 {
+		t.Fatalf("timer fired after wheel stopped")
+	}
+}
+
+// pending 预先添加n个空闲超时量级的定时器，模拟大量连接的场景
+func pending(tw *TimingWheel, n int) {
+	for i := 0; i < n; i++ {
+		tw.AfterFunc(time.Duration(60+i%60)*time.Second, func() {})
+	}
+}
+
+// BenchmarkTimingWheelAfterFuncStop 已有1M定时器时添加并停止定时器的开销
+func BenchmarkTimingWheelAfterFuncStop(b *testing.B) {
+	tw := NewTimingWheel(defaultWheelTick, defaultWheelSize)
+	pending(tw, 1000000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tw.AfterFunc(90*time.Second, func() {}).Stop()
+	}
+}
+
+// BenchmarkTimingWheelMillion 添加并停止1M定时器
+func BenchmarkTimingWheelMillion(b *testing.B) {
+	const n = 1000000
+	timers := make([]*Timer, n)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tw := NewTimingWheel(defaultWheelTick, defaultWheelSize)
+		for j := range timers {
+			timers[j] = tw.AfterFunc(time.Duration(60+j%60)*time.Second, func() {})
+		}
+		for _, t := range timers {
+			t.Stop()
+		}
+	}
+}
+
+// BenchmarkTimeAfterFuncMillion 对照：为1M连接各创建一个time.Timer并停止
+func BenchmarkTimeAfterFuncMillion(b *testing.B) {
+	const n = 1000000
+	timers := make([]*time.Timer, n)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := range timers {
+			timers[j] = time.AfterFunc(time.Duration(60+j%60)*time.Second, func() {})
+		}
+		for _, t := range timers {
+			t.Stop()
+		}
+	}
+}
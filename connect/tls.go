@@ -0,0 +1,38 @@
+package connect
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// ITLSConnection TLS连接，TLS监听器接受的连接实现此接口
+type ITLSConnection interface {
+	IConnection
+	Handshake(timeout time.Duration) error // 在timeout内完成TLS握手
+	ConnectionState() tls.ConnectionState  // 握手完成后的连接状态，含客户端证书
+}
+
+type tlsConnection struct {
+	*mConnection
+	tls *tls.Conn
+}
+
+func (t *tlsConnection) Handshake(timeout time.Duration) error {
+	if timeout > 0 {
+		if err := t.tls.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer t.tls.SetDeadline(time.Time{})
+	}
+	return t.tls.Handshake()
+}
+
+func (t *tlsConnection) ConnectionState() tls.ConnectionState {
+	return t.tls.ConnectionState()
+}
+
+func (t *tlsConnection) EnableWriteQueue(conf WriteQueueConfig) {
+	if t.queue == nil {
+		t.queue = newWriteQueue(t, t.conn, conf)
+	}
+}
@@ -0,0 +1,276 @@
+package connect
+
+import (
+	"dusnet/logger"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// maxDatagram UDP数据报的最大长度
+const maxDatagram = 65535
+
+// defaultMaxPeers 默认的对端数量上限
+const defaultMaxPeers = 65536
+
+var (
+	// ErrPeerClosed 对端虚拟连接已关闭
+	ErrPeerClosed = errors.New("udp peer closed")
+	// ErrPeerUnverified 对端地址未验证，回复超过已收到的字节数，可能被用于反射放大
+	ErrPeerUnverified = errors.New("udp peer unverified,reply exceeds received bytes")
+)
+
+// IDatagramConnection UDP对端的虚拟连接，Read只读取最近投递的数据报，不跨数据报拼接
+type IDatagramConnection interface {
+	IConnection
+	IAddrConnection
+	IActiveConnection
+	Buffered() int // 当前数据报中未读取的字节数
+	Verify()       // 对端地址已验证(如认证通过)，之后不再限制回复的字节数
+}
+
+// UDPListener UDP套接字上的对端管理，每个对端地址作为一个虚拟连接加入连接管理器，
+// 收到的数据报投递给对应的虚拟连接后由编解码器解码，虚拟连接的写入经同一套接字发往对端地址
+// 源地址可伪造，对端地址验证前发往该地址的字节数不超过从该地址收到的字节数
+type UDPListener struct {
+	conn     *net.UDPConn
+	mgr      IConnectionMgr
+	buf      []byte
+	lock     sync.Mutex
+	peers    map[netip.AddrPort]*udpConnection
+	maxPeers int
+	admit    func(addr net.Addr) (func(), error) // 新对端的准入检查
+}
+
+// ListenUDP 监听UDP地址，对端的虚拟连接加入mgr
+func ListenUDP(network string, addr *net.UDPAddr, mgr IConnectionMgr) (*UDPListener, error) {
+	conn, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPListener{
+		conn:     conn,
+		mgr:      mgr,
+		buf:      make([]byte, maxDatagram),
+		peers:    make(map[netip.AddrPort]*udpConnection),
+		maxPeers: defaultMaxPeers,
+	}, nil
+}
+
+// SetMaxPeers 设置对端数量上限，达到上限后丢弃新地址的数据报，需在开始读取前调用
+func (l *UDPListener) SetMaxPeers(max int) {
+	if max > 0 {
+		l.maxPeers = max
+	}
+}
+
+// SetAdmit 设置新对端的准入检查，如admission.Controller.Admit，拒绝时丢弃其数据报，
+// 返回的release在对端关闭时调用；需在开始读取前调用
+func (l *UDPListener) SetAdmit(admit func(addr net.Addr) (func(), error)) {
+	l.admit = admit
+}
+
+// ReadFrom 读取一个数据报并投递给来源地址的虚拟连接，fresh为true时该虚拟连接为新建；
+// 需在同一协程中循环调用，并在下次调用前读取完投递的数据
+// 超过对端数量上限或未通过准入检查的新地址的数据报被丢弃
+func (l *UDPListener) ReadFrom() (conn IDatagramConnection, fresh bool, err error) {
+	for {
+		n, raddr, err := l.conn.ReadFromUDPAddrPort(l.buf)
+		if err != nil {
+			return nil, false, err
+		}
+		raddr = unmap(raddr)
+		l.lock.Lock()
+		c, ok := l.peers[raddr]
+		peers := len(l.peers)
+		l.lock.Unlock()
+		if !ok {
+			if c = l.open(raddr, peers); c == nil {
+				continue
+			}
+		}
+		datagram := make([]byte, n)
+		copy(datagram, l.buf[:n])
+		c.fed = datagram
+		c.received(n)
+		return c, !ok, nil
+	}
+}
+
+// open 新建对端的虚拟连接，对端只在读取协程中新建，检查与加入之间对端表不会增加
+func (l *UDPListener) open(raddr netip.AddrPort, peers int) *udpConnection {
+	if peers >= l.maxPeers {
+		logger.Warn("udp peers exceed %d,datagram from %s dropped", l.maxPeers, raddr)
+		return nil
+	}
+	c := &udpConnection{
+		Activity: NewActivity(),
+		listener: l,
+		remote:   raddr,
+		alive:    true,
+	}
+	if l.admit != nil {
+		release, err := l.admit(net.UDPAddrFromAddrPort(raddr))
+		if err != nil {
+			logger.Warn("udp peer %s rejected,error:%+v", raddr, err)
+			return nil
+		}
+		c.release = release
+	}
+	c.id = l.mgr.GenConnID()
+	l.lock.Lock()
+	l.peers[raddr] = c
+	l.lock.Unlock()
+	l.mgr.AddConn(c)
+	return c
+}
+
+// LocalAddr 监听地址
+func (l *UDPListener) LocalAddr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Peers 当前的对端数量
+func (l *UDPListener) Peers() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.peers)
+}
+
+// Close 关闭套接字，对端虚拟连接随之不可写入，仍需由连接管理器移除
+func (l *UDPListener) Close() error {
+	return l.conn.Close()
+}
+
+// udpConnection UDP对端的虚拟连接，关闭时只从对端表中移除，之后同一地址的数据报将新建虚拟连接
+type udpConnection struct {
+	Activity
+	id       uint64
+	listener *UDPListener
+	remote   netip.AddrPort
+	fed      []byte // 投递的数据报，仅在读取协程中访问
+	release  func() // 对端关闭时归还准入计数
+	lock     sync.Mutex
+	alive    bool
+	verified bool // 对端地址已验证
+	credit   int  // 验证前还可发往对端的字节数
+}
+
+// received 验证前按收到的字节数增加可回复的字节数
+func (u *udpConnection) received(n int) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if !u.verified {
+		u.credit += n
+	}
+}
+
+func (u *udpConnection) Verify() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.verified = true
+}
+
+func (u *udpConnection) Read(bytes []byte) error {
+	if len(u.fed) == 0 {
+		return io.EOF
+	}
+	if len(u.fed) < len(bytes) {
+		// 数据报不完整，丢弃剩余部分
+		u.fed = nil
+		return io.ErrUnexpectedEOF
+	}
+	copy(bytes, u.fed)
+	u.fed = u.fed[len(bytes):]
+	return nil
+}
+
+// Write 每次写入作为一个数据报发送，编解码器每次写入一个完整报文；
+// 对端地址验证前写入的字节数超过收到的字节数时丢弃并返回ErrPeerUnverified
+func (u *udpConnection) Write(bytes []byte) error {
+	u.lock.Lock()
+	if !u.alive {
+		u.lock.Unlock()
+		return ErrPeerClosed
+	}
+	if !u.verified {
+		if len(bytes) > u.credit {
+			u.lock.Unlock()
+			return ErrPeerUnverified
+		}
+		u.credit -= len(bytes)
+	}
+	u.lock.Unlock()
+	_, err := u.listener.conn.WriteToUDPAddrPort(bytes, u.remote)
+	return err
+}
+
+func (u *udpConnection) Close() error {
+	u.lock.Lock()
+	u.alive = false
+	u.lock.Unlock()
+	u.listener.lock.Lock()
+	removed := u.listener.peers[u.remote] == u
+	if removed {
+		delete(u.listener.peers, u.remote)
+	}
+	u.listener.lock.Unlock()
+	if removed && u.release != nil {
+		u.release()
+	}
+	return nil
+}
+
+func (u *udpConnection) Alive() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.alive
+}
+
+func (u *udpConnection) SetAlive(alive bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.alive = alive
+}
+
+func (u *udpConnection) Buffered() int {
+	return len(u.fed)
+}
+
+func (u *udpConnection) GetID() uint64 {
+	return u.id
+}
+
+func (u *udpConnection) SetID(id uint64) {
+	u.id = id
+}
+
+func (u *udpConnection) GetLocalHost() string {
+	return HostOf(u.listener.conn.LocalAddr())
+}
+
+func (u *udpConnection) GetLocalPort() int {
+	return PortOf(u.listener.conn.LocalAddr())
+}
+
+func (u *udpConnection) GetRemoteHost() string {
+	return u.remote.Addr().String()
+}
+
+func (u *udpConnection) GetRemotePort() int {
+	return int(u.remote.Port())
+}
+
+func (u *udpConnection) LocalAddr() net.Addr {
+	return u.listener.conn.LocalAddr()
+}
+
+func (u *udpConnection) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(u.remote)
+}
+
+func (u *udpConnection) RemoteAddrPort() netip.AddrPort {
+	return u.remote
+}
@@ -0,0 +1,182 @@
+package connect
+
+import (
+	"dusnet/logger"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// SlowConsumerPolicy 待写入数据超过高水位时的处理策略
+type SlowConsumerPolicy int
+
+const (
+	SlowConsumerDrop       SlowConsumerPolicy = iota // 丢弃本次写入
+	SlowConsumerDisconnect                           // 断开连接
+)
+
+const (
+	defaultHighWater    = 4 << 20 // 4MB
+	defaultWriteTimeout = 10 * time.Second
+)
+
+var (
+	ErrQueueFull   = errors.New("connection write queue exceeds high water")
+	ErrQueueClosed = errors.New("connection write queue closed")
+)
+
+// WriteQueueConfig 异步写队列配置
+type WriteQueueConfig struct {
+	HighWater      int                                      // 待写入字节数高水位，超过时按Policy处理，默认4MB
+	WriteTimeout   time.Duration                            // 单次批量写入的超时时间，超时后断开连接，默认10秒；关闭连接时待写入的报文最长等待该时间写出
+	Policy         SlowConsumerPolicy                       // 慢消费者处理策略
+	OnSlowConsumer func(conn IConnection, stats QueueStats) // 超过高水位时的回调，在执行Policy之前调用，不可阻塞
+}
+
+// QueueStats 写队列统计
+type QueueStats struct {
+	Depth   int    `json:"depth"`   // 待写入的报文数
+	Bytes   int    `json:"bytes"`   // 待写入的字节数
+	Written uint64 `json:"written"` // 已写入的报文数
+	Dropped uint64 `json:"dropped"` // 因超过高水位丢弃的报文数
+}
+
+// IQueuedConnection 支持异步写队列的连接，connect.New返回的连接均实现此接口
+type IQueuedConnection interface {
+	IConnection
+	EnableWriteQueue(conf WriteQueueConfig) // 启用异步写队列，之后Write只入队，由单独的写协程按序写出
+	QueueStats() QueueStats                 // 写队列统计
+}
+
+// writeQueue 连接的异步写队列，入队的每个缓冲区都是完整报文，单个写协程按序批量写出，
+// 多个协程并发写同一连接时报文不会交错，慢设备也不会阻塞写入方
+type writeQueue struct {
+	owner   IConnection
+	conn    net.Conn
+	conf    WriteQueueConfig
+	lock    sync.Mutex
+	cond    *sync.Cond
+	bufs    [][]byte
+	bytes   int
+	written uint64
+	dropped uint64
+	closed  bool
+	flushBy time.Time     // 关闭时待写入报文的写出截止时间
+	done    chan struct{} // 写协程退出时关闭
+}
+
+func newWriteQueue(owner IConnection, conn net.Conn, conf WriteQueueConfig) *writeQueue {
+	if conf.HighWater <= 0 {
+		conf.HighWater = defaultHighWater
+	}
+	if conf.WriteTimeout <= 0 {
+		conf.WriteTimeout = defaultWriteTimeout
+	}
+	q := &writeQueue{owner: owner, conn: conn, conf: conf, done: make(chan struct{})}
+	q.cond = sync.NewCond(&q.lock)
+	go q.run()
+	return q
+}
+
+// push 入队，入队的缓冲区写出前不可修改，多个连接可共享同一缓冲区
+func (q *writeQueue) push(buf []byte) error {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return ErrQueueClosed
+	}
+	if q.bytes+len(buf) > q.conf.HighWater {
+		q.dropped++
+		stats := q.statsLocked()
+		q.lock.Unlock()
+		if q.conf.OnSlowConsumer != nil {
+			q.conf.OnSlowConsumer(q.owner, stats)
+		}
+		if q.conf.Policy == SlowConsumerDisconnect {
+			logger.Warn("connection[id=%d] slow consumer with %d bytes pending,disconnect", q.owner.GetID(), stats.Bytes)
+			// 慢连接不再写出待写入的报文，直接断开
+			q.discard()
+			_ = q.conn.Close()
+			_ = q.owner.Close()
+		}
+		return ErrQueueFull
+	}
+	q.bufs = append(q.bufs, buf)
+	q.bytes += len(buf)
+	q.cond.Signal()
+	q.lock.Unlock()
+	return nil
+}
+
+// run 按序写出队列中的报文，队列关闭后写完剩余报文再退出
+func (q *writeQueue) run() {
+	defer close(q.done)
+	for {
+		q.lock.Lock()
+		for len(q.bufs) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.bufs) == 0 {
+			q.lock.Unlock()
+			return
+		}
+		bufs := q.bufs
+		size := q.bytes
+		q.bufs = nil
+		q.bytes = 0
+		deadline := time.Now().Add(q.conf.WriteTimeout)
+		if q.closed {
+			deadline = q.flushBy
+		}
+		q.lock.Unlock()
+
+		_ = q.conn.SetWriteDeadline(deadline)
+		batch := net.Buffers(bufs)
+		if _, err := batch.WriteTo(q.conn); err != nil {
+			logger.Warn("connection[id=%d] write %d bytes error,error:%+v", q.owner.GetID(), size, err)
+			// 关闭套接字后读取侧随之出错，由读取侧释放连接；不经owner.Close，避免等待写协程自身退出
+			q.discard()
+			_ = q.conn.Close()
+			return
+		}
+		q.lock.Lock()
+		q.written += uint64(len(bufs))
+		q.lock.Unlock()
+	}
+}
+
+func (q *writeQueue) stats() QueueStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.statsLocked()
+}
+
+func (q *writeQueue) statsLocked() QueueStats {
+	return QueueStats{Depth: len(q.bufs), Bytes: q.bytes, Written: q.written, Dropped: q.dropped}
+}
+
+// close 关闭队列，不再接受写入，等待已入队的报文在写超时内写出后返回
+func (q *writeQueue) close() {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		q.flushBy = time.Now().Add(q.conf.WriteTimeout)
+		q.cond.Broadcast()
+	}
+	q.lock.Unlock()
+	<-q.done
+}
+
+// discard 关闭队列并丢弃待写入的报文
+func (q *writeQueue) discard() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.flushBy = time.Now()
+	}
+	q.bufs = nil
+	q.bytes = 0
+	q.cond.Broadcast()
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"dusnet/auth"
+	"dusnet/packet"
+)
+
+// Auth8000Handler 认证握手处理器，连接认证完成前只能路由到此处理器
+type Auth8000Handler struct {
+	baseHandler
+	guard *auth.Guard
+}
+
+// NewAuth8000Handler 以guard完成握手构建处理器
+func NewAuth8000Handler(guard *auth.Guard) *Auth8000Handler {
+	return &Auth8000Handler{guard: guard}
+}
+
+// HandleMsg 认证失败时回复失败原因并返回错误，连接随之释放
+func (p Auth8000Handler) HandleMsg(pkt packet.IPacket) error {
+	reply, err := p.guard.Handshake(p.conn, pkt.GetData())
+	ackPkt := packet.Packet{}
+	ackPkt.ID = pkt.GetID()
+	ackPkt.Type = pkt.GetType()
+	if err != nil {
+		ackPkt.Data = []byte(err.Error())
+		_ = p.write(&ackPkt)
+		return err
+	}
+	ackPkt.Data = reply
+	return p.write(&ackPkt)
+}
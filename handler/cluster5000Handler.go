@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"dusnet/cluster"
+	"dusnet/logger"
+	"dusnet/packet"
+)
+
+// Cluster5000Handler 集群节点间链路处理器，接收对端节点的设备映射变化及转发报文
+type Cluster5000Handler struct {
+	baseHandler
+	node *cluster.Node
+}
+
+// NewCluster5000Handler 构建集群处理器，注册后当前节点即可作为集群成员接收对端消息
+func NewCluster5000Handler(node *cluster.Node) *Cluster5000Handler {
+	return &Cluster5000Handler{node: node}
+}
+
+func (p Cluster5000Handler) HandleMsg(pkt packet.IPacket) error {
+	logger.Debug("handle cluster5000 msg with pkt:%+v", pkt)
+	return p.node.HandleMessage(p.conn, pkt.GetData())
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"dusnet/registry"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var errWatching = errors.New("registry watch already active on this connection")
+
+// Registry4000Handler 内置注册中心处理器，注册后当前节点即可通过dusnet协议对外提供注册中心服务
+type Registry4000Handler struct {
+	baseHandler
+	reg     registry.Registry
+	watches *watches
+}
+
+// watches 各连接上的监听，每个连接只允许一个，连接释放时停止
+type watches struct {
+	lock  sync.Mutex
+	conns map[connect.IConnection]registry.Watcher // 监听建立前为空
+}
+
+// NewRegistry4000Handler 以reg作为存储构建注册中心处理器
+func NewRegistry4000Handler(reg registry.Registry) *Registry4000Handler {
+	return &Registry4000Handler{
+		reg:     reg,
+		watches: &watches{conns: make(map[connect.IConnection]registry.Watcher)},
+	}
+}
+
+func (p Registry4000Handler) HandleMsg(pkt packet.IPacket) error {
+	logger.Debug("handle registry4000 msg with pkt:%+v", pkt)
+	req := &registry.Request{}
+	if err := json.Unmarshal(pkt.GetData(), req); err != nil {
+		return p.reply(&registry.Response{Error: err.Error()})
+	}
+	if req.Op == registry.OpWatch {
+		return p.watch(req.Name)
+	}
+	return p.reply(registry.Serve(p.reg, req))
+}
+
+// watch 监听服务变化并在当前连接上持续推送，直到连接释放或写入失败，同一连接只能监听一次；
+// 推送与其他处理器的应答并发写入同一连接，由连接的Write保证报文不交错
+func (p Registry4000Handler) watch(name string) error {
+	if p.watches == nil {
+		return p.reply(&registry.Response{Error: "registry handler not created by NewRegistry4000Handler"})
+	}
+	conn := p.conn
+	p.watches.lock.Lock()
+	if _, ok := p.watches.conns[conn]; ok {
+		p.watches.lock.Unlock()
+		return p.reply(&registry.Response{Error: errWatching.Error()})
+	}
+	p.watches.conns[conn] = nil
+	p.watches.lock.Unlock()
+	watcher, err := p.reg.Watch(name)
+	if err != nil {
+		p.unwatch(conn, nil)
+		return p.reply(&registry.Response{Error: err.Error()})
+	}
+	p.watches.lock.Lock()
+	_, ok := p.watches.conns[conn]
+	if ok {
+		p.watches.conns[conn] = watcher
+	}
+	p.watches.lock.Unlock()
+	if !ok || !conn.Alive() {
+		// 建立监听期间连接已释放
+		p.unwatch(conn, watcher)
+		return nil
+	}
+	go func() {
+		defer p.unwatch(conn, watcher)
+		for {
+			instances, err := watcher.Next()
+			if err != nil {
+				return
+			}
+			if err := p.reply(&registry.Response{Instances: instances}); err != nil {
+				logger.Warn("push registry[%s] changes error,error:%+v", name, err)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// unwatch 停止conn上的监听watcher
+func (p Registry4000Handler) unwatch(conn connect.IConnection, watcher registry.Watcher) {
+	p.watches.lock.Lock()
+	if current, ok := p.watches.conns[conn]; ok && current == watcher {
+		delete(p.watches.conns, conn)
+	}
+	p.watches.lock.Unlock()
+	if watcher != nil {
+		_ = watcher.Stop()
+	}
+}
+
+// ReleaseConn 连接释放时停止其上的监听
+func (p Registry4000Handler) ReleaseConn(conn connect.IConnection) {
+	if p.watches == nil {
+		return
+	}
+	p.watches.lock.Lock()
+	watcher := p.watches.conns[conn]
+	delete(p.watches.conns, conn)
+	p.watches.lock.Unlock()
+	if watcher != nil {
+		_ = watcher.Stop()
+	}
+}
+
+func (p Registry4000Handler) reply(resp *registry.Response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	ackPkt := packet.Packet{}
+	ackPkt.ID = registry.RouteID
+	ackPkt.Type = zcodec.TYPE_BUSINESS
+	ackPkt.Data = data
+	return p.write(&ackPkt)
+}
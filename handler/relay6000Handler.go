@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"dusnet/auth"
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"dusnet/relay"
+)
+
+// Relay6000Handler 中继链路处理器，注册后当前节点即可作为跳板机的上游节点，
+// 经跳板机接入的设备以虚拟连接的形式交给server，与直连设备一样认证及路由，需配合server.WithRelayUpstream使用
+type Relay6000Handler struct {
+	baseHandler
+	upstream *relay.Upstream
+	guard    *auth.Guard // 中继链路须经guard认证
+}
+
+// NewRelay6000Handler 构建中继链路处理器，codec为设备报文及中继帧使用的编解码器
+func NewRelay6000Handler(codec zcodec.Icodec) *Relay6000Handler {
+	h := &Relay6000Handler{}
+	h.codec0 = codec
+	h.upstream = relay.NewUpstream(nil)
+	h.upstream.SetCodec(codec)
+	return h
+}
+
+// Serve 只接受经guard认证的中继链路，设备接入时调用onOpen，由server.WithRelayUpstream在启动时设置
+func (p *Relay6000Handler) Serve(guard *auth.Guard, onOpen func(connect.IConnection)) {
+	p.guard = guard
+	p.upstream.OnOpen(onOpen)
+}
+
+func (p Relay6000Handler) HandleMsg(pkt packet.IPacket) error {
+	if p.guard == nil || !p.guard.Authenticated(p.conn) {
+		logger.Warn("relay link connection[id=%d,raddr:%s:%d] not authenticated,rejected",
+			p.conn.GetID(), p.conn.GetRemoteHost(), p.conn.GetRemotePort())
+		return auth.ErrUnauthenticated
+	}
+	return p.upstream.Handle(p.conn, pkt.GetData())
+}
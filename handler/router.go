@@ -2,10 +2,14 @@ package handler
 
 import (
 	zcodec "dusnet/codec"
+	"dusnet/connect"
 	"dusnet/packet"
+	"reflect"
 )
 
 // IHandler 业务处理器接口，所有业务子类handler均实现此接口
+// 处理协程池中多个协程可同时路由到同一处理器，每次路由时复制注册的处理器后再绑定连接，
+// 因此处理器的字段应为配置或指向共享状态的指针，HandleMsg中修改的非指针字段不会保留
 type IHandler interface {
 	IBaseHandler
 	HandleMsg(packet.IPacket) error
@@ -20,6 +24,26 @@ func AllChildHandlers() map[uint32]IHandler {
 	return childHandlerMap
 }
 
+// ReleaseConn 连接释放时通知实现IConnReleaser的子处理器
+func ReleaseConn(conn connect.IConnection) {
+	for _, h := range childHandlerMap {
+		if r, ok := h.(IConnReleaser); ok {
+			r.ReleaseConn(conn)
+		}
+	}
+}
+
+// handleChild 复制子处理器并绑定conn后处理报文，避免并发路由时SetConn互相覆盖导致应答写错连接
+func handleChild(h IHandler, conn connect.IConnection, pkt packet.IPacket) error {
+	if v := reflect.ValueOf(h); v.Kind() == reflect.Pointer && v.Elem().Kind() == reflect.Struct {
+		clone := reflect.New(v.Elem().Type())
+		clone.Elem().Set(v.Elem())
+		h = clone.Interface().(IHandler)
+	}
+	h.SetConn(conn)
+	return h.HandleMsg(pkt)
+}
+
 var childHandlerMap = map[uint32]IHandler{
 	2000: &sync2000Handler{},
 	3000: &rpc3000Handler{
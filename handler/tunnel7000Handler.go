@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"dusnet/logger"
+	"dusnet/packet"
+	"dusnet/tunnel"
+)
+
+// Tunnel7000Handler 反向隧道处理器，设备经已有连接注册隧道后，服务端在本地打开监听端口转发到设备
+type Tunnel7000Handler struct {
+	baseHandler
+	manager *tunnel.Manager
+}
+
+// NewTunnel7000Handler 以manager管理隧道构建处理器
+func NewTunnel7000Handler(manager *tunnel.Manager) *Tunnel7000Handler {
+	return &Tunnel7000Handler{manager: manager}
+}
+
+func (p Tunnel7000Handler) HandleMsg(pkt packet.IPacket) error {
+	if err := p.manager.Handle(p.conn, pkt.GetData()); err != nil {
+		logger.Warn("handle tunnel7000 msg error,error:%+v", err)
+	}
+	return nil
+}
@@ -23,6 +23,30 @@ type IRouteHandler interface {
 	HandleMsg0() error
 }
 
+// IConnRouter 可由多个连接共享的路由处理器，server据此在读取协程、处理协程池及reactor中路由报文；
+// 未实现时server为每个报文绑定连接后调用HandleMsg0
+type IConnRouter interface {
+	HandleConn(connect.IConnection) error                   // 从指定连接读取并路由一个报文
+	HandlePacket(connect.IConnection, packet.IPacket) error // 路由已解码的报文
+}
+
+// IInterceptable 支持路由拦截器的路由处理器，认证、访问控制、限流及主备重定向依赖此接口
+type IInterceptable interface {
+	AddInterceptor(Interceptor) // 添加路由拦截器
+}
+
+// IConnReleaser 持有连接相关状态的子处理器，连接释放时由server调用ReleaseConn清理，如停止连接上的推送
+type IConnReleaser interface {
+	ReleaseConn(connect.IConnection)
+}
+
+// Interceptor 路由拦截器，在子处理器处理前按添加顺序执行，返回错误时不再路由且连接将被释放，
+// 返回ErrSkip时仅丢弃该报文
+type Interceptor func(conn connect.IConnection, pkt packet.IPacket) error
+
+// ErrSkip 拦截器已处理该报文(如已回复拒绝)，不再路由，连接保持
+var ErrSkip = errors.New("msg skipped by interceptor")
+
 // IBuilder 路由处理器构建接口
 type IBuilder interface { // 默认路由handler构造器接口
 	Codec(zcodec.Icodec) IBuilder
@@ -44,7 +68,7 @@ func (h *baseHandler) write(pkt packet.IPacket) error {
 		// 使用默认编解码器
 		h.codec0 = zcodec.Default()
 	}
-	buf, err := h.codec0.Encode(pkt)
+	buf, err := zcodec.EncodeFor(h.codec0, h.conn, pkt)
 	if err != nil {
 		return err
 	}
@@ -54,28 +78,51 @@ func (h *baseHandler) write(pkt packet.IPacket) error {
 // 路由处理器，较baseHandler多实现了路由的函数HandleMsg0
 type routerHandler struct {
 	baseHandler
+	interceptors []Interceptor
 }
 
 func (hr *routerHandler) HandleMsg0() error {
-	if hr.conn == nil || !hr.conn.Alive() {
+	return hr.HandleConn(hr.conn)
+}
+
+// HandleConn 从conn读取并路由一个报文，多个连接共享同一路由处理器时使用，避免绑定连接互相覆盖
+func (hr *routerHandler) HandleConn(conn connect.IConnection) error {
+	if conn == nil || !conn.Alive() {
 		logger.Error("connection not alive with baseHandler[%+v]", hr)
-		return errors.New(fmt.Sprintf("connection[%+v] not alive with baseHandler[%+v]", hr.conn, hr))
+		return errors.New(fmt.Sprintf("connection[%+v] not alive with baseHandler[%+v]", conn, hr))
 	}
-	pkt, err := hr.codec0.Decode(hr.conn)
+	pkt, err := hr.codec0.Decode(conn)
 	if err != nil {
 		logger.Error("msg decode error,error:%+v", err)
 		return err
 	}
+	return hr.HandlePacket(conn, pkt)
+}
+
+// HandlePacket 路由已解码的报文，读取与处理分离时由处理协程调用
+func (hr *routerHandler) HandlePacket(conn connect.IConnection, pkt packet.IPacket) error {
 	logger.Debug("Receive msg[Head{id:%d,type:%d,length:%d}-Body{%s}] from address[%s:%d]",
-		pkt.GetID(), pkt.GetType(), pkt.GetBodyLen(), string(pkt.GetData()), hr.conn.GetRemoteHost(), hr.conn.GetRemotePort())
-	// todo renewal the connection
+		pkt.GetID(), pkt.GetType(), pkt.GetBodyLen(), string(pkt.GetData()), conn.GetRemoteHost(), conn.GetRemotePort())
+	// 收到任意报文即续租
+	connect.Renew(conn)
+	for _, interceptor := range hr.interceptors {
+		if err := interceptor(conn, pkt); err != nil {
+			if errors.Is(err, ErrSkip) {
+				return nil
+			}
+			return err
+		}
+	}
 	if h, ok := childHandlerMap[pkt.GetID()]; ok {
-		h.SetConn(hr.conn)
-		return h.HandleMsg(pkt)
+		return handleChild(h, conn, pkt)
 	}
 	return errors.New(fmt.Sprintf("No childHandler to handle this msg[type:%d,id:%d]", pkt.GetType(), pkt.GetID()))
 }
 
+func (hr *routerHandler) AddInterceptor(interceptor Interceptor) {
+	hr.interceptors = append(hr.interceptors, interceptor)
+}
+
 func (h *baseHandler) BindConn(conn connect.IConnection) {
 	h.conn = conn
 }
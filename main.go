@@ -4,27 +4,38 @@ import (
 	zcodec "dusnet/codec"
 	"dusnet/handler"
 	"dusnet/logger"
+	"dusnet/registry"
 	"dusnet/server"
 )
 
+// serviceName 各server在注册中心中注册的服务名称
+const serviceName = "dusnet"
+
+// advertiseHost 各server监听通配地址，注册到注册中心供客户端连接的地址
+const advertiseHost = "127.0.0.1"
+
 func main() {
-	s1 := startServer("mint_server1", "tcp", "0.0.0.0", 9000)
+	// mint_server1同时作为注册中心对外提供服务
+	reg := registry.NewMemory()
+	handler.RegisterChildHandler(registry.RouteID, handler.NewRegistry4000Handler(reg))
+
+	s1 := startServer(reg, "mint_server1", "tcp", "0.0.0.0", 9000)
 	defer s1.Stop()
 
-	s2 := startServer("mint_server2", "tcp", "0.0.0.0", 9001)
+	s2 := startServer(reg, "mint_server2", "tcp", "0.0.0.0", 9001)
 	defer s2.Stop()
 
-	s3 := startServer("mint_server3", "tcp", "0.0.0.0", 9002)
+	s3 := startServer(reg, "mint_server3", "tcp", "0.0.0.0", 9002)
 	defer s3.Stop()
 
 	select {}
 }
 
-func startServer(name, network, host string, port int) server.IServer {
+func startServer(reg registry.Registry, name, network, host string, port int) server.IServer {
 	ping1000Handler := handler.Ping1000Handler{}
 	ping1000Handler.SetCodec(zcodec.Default())
 	handler.RegisterChildHandler(1000, &ping1000Handler)
-	s := server.Default(name, network, host, port)
+	s := server.Default(name, network, host, port, server.WithRegistry(reg, serviceName), server.WithAdvertise(advertiseHost))
 	err := s.Start()
 	if err != nil {
 		logger.Error("server[%+v] start error", s)
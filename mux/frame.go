@@ -0,0 +1,46 @@
+package mux
+
+import (
+	"encoding/binary"
+)
+
+// 帧类型
+const (
+	typeOpen   byte = iota + 1 // 打开流
+	typeData                   // 流数据
+	typeWindow                 // 窗口更新，length字段为增加的窗口大小，无载荷
+	typeClose                  // 关闭流的写方向
+	typeReset                  // 重置流，拒绝对端打开的流或对端未关闭时本端关闭流时发送，双向关闭
+)
+
+const (
+	headLen       = 9          // 帧头长度 type(1) + streamID(4) + length(4)
+	initialWindow = 256 * 1024 // 每个流的初始接收窗口
+	maxFrameSize  = 32 * 1024  // 单帧最大载荷
+	maxStreams    = 1024       // 每个会话最大流数量，超过时重置对端新打开的流
+	acceptBacklog = 64         // 对端打开且尚未Accept的流数量上限，超过时重置新打开的流
+)
+
+// frameHead 帧头
+type frameHead struct {
+	typ    byte
+	stream uint32
+	length uint32
+}
+
+func (h frameHead) encode(payload []byte) []byte {
+	buf := make([]byte, headLen+len(payload))
+	buf[0] = h.typ
+	binary.BigEndian.PutUint32(buf[1:5], h.stream)
+	binary.BigEndian.PutUint32(buf[5:9], h.length)
+	copy(buf[headLen:], payload)
+	return buf
+}
+
+func decodeHead(buf []byte) frameHead {
+	return frameHead{
+		typ:    buf[0],
+		stream: binary.BigEndian.Uint32(buf[1:5]),
+		length: binary.BigEndian.Uint32(buf[5:9]),
+	}
+}
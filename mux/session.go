@@ -0,0 +1,225 @@
+package mux
+
+import (
+	"dusnet/connect"
+	"dusnet/logger"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrSessionClosed  = errors.New("mux session closed")
+	ErrStreamClosed   = errors.New("mux stream closed")
+	ErrStreamReset    = errors.New("mux stream reset by peer")
+	ErrTooManyStreams = errors.New("mux session too many streams")
+)
+
+// Session 在一条连接上复用多个逻辑流，每个流实现connect.IConnection，可直接交给编解码器及路由处理器使用
+// 帧格式为 type(1) + streamID(4) + length(4) + 载荷，每个流独立进行基于窗口的流量控制
+type Session struct {
+	conn      connect.IConnection
+	nextID    uint32 // 下一个本端打开的流id，客户端为奇数，服务端为偶数
+	lock      sync.Mutex
+	streams   map[uint32]*Stream
+	accept    chan *Stream // 对端打开的流
+	writeLock sync.Mutex   // 保证帧写入不交错
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Client 以客户端身份在conn上建立复用会话，会话独占conn的读写
+func Client(conn connect.IConnection) *Session {
+	return newSession(conn, 1)
+}
+
+// Server 以服务端身份在conn上建立复用会话，会话独占conn的读写
+func Server(conn connect.IConnection) *Session {
+	return newSession(conn, 2)
+}
+
+func newSession(conn connect.IConnection, firstID uint32) *Session {
+	s := &Session{
+		conn:    conn,
+		nextID:  firstID,
+		streams: make(map[uint32]*Stream),
+		accept:  make(chan *Stream, acceptBacklog),
+		closed:  make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// Open 打开一个新的流
+func (s *Session) Open() (*Stream, error) {
+	s.lock.Lock()
+	if s.IsClosed() {
+		s.lock.Unlock()
+		return nil, ErrSessionClosed
+	}
+	if len(s.streams) >= maxStreams {
+		s.lock.Unlock()
+		return nil, ErrTooManyStreams
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(id, s)
+	s.streams[id] = stream
+	s.lock.Unlock()
+	if err := s.writeFrame(frameHead{typ: typeOpen, stream: id}, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept 等待对端打开的流
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case stream := <-s.accept:
+		return stream, nil
+	case <-s.closed:
+		return nil, ErrSessionClosed
+	}
+}
+
+// NumStreams 当前流数量
+func (s *Session) NumStreams() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed 会话是否已关闭
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Closed 会话关闭时关闭的通道
+func (s *Session) Closed() <-chan struct{} {
+	return s.closed
+}
+
+// Close 关闭会话、底层连接及所有流
+func (s *Session) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+		s.lock.Lock()
+		streams := s.streams
+		s.streams = make(map[uint32]*Stream)
+		s.lock.Unlock()
+		for _, stream := range streams {
+			stream.abort()
+		}
+	})
+	return err
+}
+
+func (s *Session) writeFrame(head frameHead, payload []byte) error {
+	if s.IsClosed() {
+		return ErrSessionClosed
+	}
+	buf := head.encode(payload)
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if err := s.conn.Write(buf); err != nil {
+		_ = s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.streams, id)
+}
+
+func (s *Session) recvLoop() {
+	defer s.Close()
+	headBuf := make([]byte, headLen)
+	for {
+		if err := s.conn.Read(headBuf); err != nil {
+			if !s.IsClosed() {
+				logger.Warn("mux session read head error,error:%+v", err)
+			}
+			return
+		}
+		head := decodeHead(headBuf)
+		if err := s.handleFrame(head); err != nil {
+			logger.Error("mux session handle frame[type:%d,stream:%d] error,error:%+v", head.typ, head.stream, err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(head frameHead) error {
+	switch head.typ {
+	case typeOpen:
+		if head.stream == 0 || head.stream%2 == s.nextID%2 {
+			// 对端只能使用与本端奇偶性相反的流id
+			return fmt.Errorf("stream id %d not allowed for peer", head.stream)
+		}
+		s.lock.Lock()
+		if _, ok := s.streams[head.stream]; ok {
+			s.lock.Unlock()
+			return fmt.Errorf("duplicate stream id %d", head.stream)
+		}
+		if len(s.streams) >= maxStreams {
+			s.lock.Unlock()
+			logger.Warn("mux session streams exceed %d,stream[%d] reset", maxStreams, head.stream)
+			return s.writeFrame(frameHead{typ: typeReset, stream: head.stream}, nil)
+		}
+		stream := newStream(head.stream, s)
+		s.streams[head.stream] = stream
+		s.lock.Unlock()
+		select {
+		case s.accept <- stream:
+		default:
+			// 不阻塞接收循环，否则已建立流的数据及窗口更新也无法处理
+			s.removeStream(head.stream)
+			logger.Warn("mux session accept backlog full,stream[%d] reset", head.stream)
+			return s.writeFrame(frameHead{typ: typeReset, stream: head.stream}, nil)
+		}
+	case typeData:
+		if head.length > maxFrameSize {
+			return fmt.Errorf("frame length %d exceeds max frame size", head.length)
+		}
+		payload := make([]byte, head.length)
+		if err := s.conn.Read(payload); err != nil {
+			return err
+		}
+		if stream := s.stream(head.stream); stream != nil {
+			return stream.receive(payload)
+		}
+	case typeWindow:
+		if stream := s.stream(head.stream); stream != nil {
+			stream.grow(head.length)
+		}
+	case typeClose:
+		if stream := s.stream(head.stream); stream != nil {
+			stream.remoteClose()
+		}
+	case typeReset:
+		if stream := s.stream(head.stream); stream != nil {
+			s.removeStream(head.stream)
+			stream.abort()
+		}
+	default:
+		return fmt.Errorf("frame type %d not defined", head.typ)
+	}
+	return nil
+}
+
+func (s *Session) stream(id uint32) *Stream {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.streams[id]
+}
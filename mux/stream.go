@@ -0,0 +1,226 @@
+package mux
+
+import (
+	"dusnet/connect"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// Stream 复用会话上的逻辑流，实现connect.IConnection
+type Stream struct {
+	connect.Activity
+	id           uint32
+	connID       uint64 // 连接id，可由连接管理器分配，默认与流id一致
+	session      *Session
+	lock         sync.Mutex
+	cond         *sync.Cond
+	recvBuf      []byte // 已收到未读取的数据
+	recvWindow   uint32 // 对端剩余可发送的窗口
+	consumed     uint32 // 已读取但尚未通知对端的窗口
+	sendWindow   uint32 // 本端剩余可发送的窗口
+	alive        bool
+	localClosed  bool // 本端已关闭
+	remoteClosed bool // 对端已关闭写方向
+}
+
+func newStream(id uint32, session *Session) *Stream {
+	s := &Stream{
+		Activity:   connect.NewActivity(),
+		id:         id,
+		connID:     uint64(id),
+		session:    session,
+		recvWindow: initialWindow,
+		sendWindow: initialWindow,
+		alive:      true,
+	}
+	s.cond = sync.NewCond(&s.lock)
+	return s
+}
+
+// Renew 续租流及其所在的会话连接，避免会话连接因空闲被驱逐
+func (s *Stream) Renew() {
+	s.Activity.Renew()
+	connect.Renew(s.session.conn)
+}
+
+// StreamID 流id
+func (s *Stream) StreamID() uint32 {
+	return s.id
+}
+
+func (s *Stream) receive(payload []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if uint32(len(payload)) > s.recvWindow {
+		return errors.New("mux stream receive window exceeded")
+	}
+	s.recvWindow -= uint32(len(payload))
+	s.recvBuf = append(s.recvBuf, payload...)
+	s.cond.Broadcast()
+	return nil
+}
+
+// grow 对端读取后增加发送窗口，窗口不超过初始窗口，避免对端多次通知使窗口溢出
+func (s *Stream) grow(delta uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if delta > initialWindow-s.sendWindow {
+		delta = initialWindow - s.sendWindow
+	}
+	s.sendWindow += delta
+	s.cond.Broadcast()
+}
+
+func (s *Stream) remoteClose() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remoteClosed = true
+	s.cond.Broadcast()
+}
+
+// abort 会话关闭或对端重置时终止流，已收到的数据仍可读取
+func (s *Stream) abort() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remoteClosed = true
+	s.alive = false
+	s.cond.Broadcast()
+}
+
+// Read 读满bytes，对端关闭或流被释放且数据读完后返回io.EOF，本端关闭后返回ErrStreamClosed
+func (s *Stream) Read(bytes []byte) error {
+	s.lock.Lock()
+	n := 0
+	for n < len(bytes) {
+		for len(s.recvBuf) == 0 && !s.remoteClosed && !s.localClosed && s.alive {
+			s.cond.Wait()
+		}
+		if s.localClosed {
+			s.lock.Unlock()
+			return ErrStreamClosed
+		}
+		if len(s.recvBuf) == 0 {
+			s.lock.Unlock()
+			return io.EOF
+		}
+		c := copy(bytes[n:], s.recvBuf)
+		s.recvBuf = s.recvBuf[c:]
+		n += c
+		s.consumed += uint32(c)
+		// 累计读取超过半个窗口时通知对端，读取大于窗口的数据时也能持续收到
+		if s.consumed >= initialWindow/2 {
+			delta := s.consumed
+			s.recvWindow += delta
+			s.consumed = 0
+			s.lock.Unlock()
+			if err := s.session.writeFrame(frameHead{typ: typeWindow, stream: s.id, length: delta}, nil); err != nil {
+				return err
+			}
+			s.lock.Lock()
+		}
+	}
+	s.lock.Unlock()
+	return nil
+}
+
+// Write 按对端窗口分帧写入，窗口耗尽时阻塞直到对端读取
+func (s *Stream) Write(bytes []byte) error {
+	for len(bytes) > 0 {
+		s.lock.Lock()
+		for s.sendWindow == 0 && !s.localClosed && s.alive {
+			s.cond.Wait()
+		}
+		if s.localClosed || !s.alive {
+			s.lock.Unlock()
+			return ErrStreamClosed
+		}
+		n := uint32(len(bytes))
+		if n > s.sendWindow {
+			n = s.sendWindow
+		}
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		s.sendWindow -= n
+		s.lock.Unlock()
+		if err := s.session.writeFrame(frameHead{typ: typeData, stream: s.id, length: n}, bytes[:n]); err != nil {
+			return err
+		}
+		bytes = bytes[n:]
+	}
+	return nil
+}
+
+// Close 关闭流并从会话中移除，阻塞中的Read、Write随即返回。
+// 对端已关闭时通知对端关闭，否则重置对端的流，避免对端继续写入时因窗口耗尽阻塞
+func (s *Stream) Close() error {
+	s.lock.Lock()
+	if s.localClosed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	typ := typeReset
+	if s.remoteClosed {
+		typ = typeClose
+	}
+	s.cond.Broadcast()
+	s.lock.Unlock()
+	s.session.removeStream(s.id)
+	if s.session.IsClosed() {
+		return nil
+	}
+	return s.session.writeFrame(frameHead{typ: typ, stream: s.id}, nil)
+}
+
+func (s *Stream) Alive() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.alive && !s.localClosed && !s.session.IsClosed()
+}
+
+func (s *Stream) SetAlive(alive bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.alive = alive
+	s.cond.Broadcast()
+}
+
+func (s *Stream) GetID() uint64 {
+	return s.connID
+}
+
+func (s *Stream) SetID(id uint64) {
+	s.connID = id
+}
+
+func (s *Stream) GetLocalHost() string {
+	return s.session.conn.GetLocalHost()
+}
+
+func (s *Stream) GetLocalPort() int {
+	return s.session.conn.GetLocalPort()
+}
+
+func (s *Stream) GetRemoteHost() string {
+	return s.session.conn.GetRemoteHost()
+}
+
+func (s *Stream) GetRemotePort() int {
+	return s.session.conn.GetRemotePort()
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return connect.LocalAddrOf(s.session.conn)
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return connect.RemoteAddrOf(s.session.conn)
+}
+
+func (s *Stream) RemoteAddrPort() netip.AddrPort {
+	return connect.RemoteAddrPortOf(s.session.conn)
+}
@@ -0,0 +1,135 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v2Signature PROXY协议v2的12字节签名
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLen PROXY协议v1报文头最大长度，含结尾的\r\n
+const v1MaxLen = 107
+
+var (
+	ErrInvalidHeader = errors.New("invalid proxy protocol header")
+	ErrUnsupported   = errors.New("unsupported proxy protocol address family")
+)
+
+// Header 解析得到的PROXY协议报文头
+type Header struct {
+	Version int      // 1或2
+	Local   bool     // v2 LOCAL命令或v1 UNKNOWN，如负载均衡的健康检查，使用连接本身的地址
+	Source  net.Addr // 真实客户端地址
+	Dest    net.Addr // 客户端连接的负载均衡地址
+}
+
+// readHeader 读取PROXY协议报文头，first为已读取的首字节，不会多读报文头之后的数据
+func readHeader(r io.Reader, first byte) (*Header, error) {
+	switch first {
+	case 'P':
+		return readV1(r)
+	case v2Signature[0]:
+		return readV2(r)
+	}
+	return nil, ErrInvalidHeader
+}
+
+// readV1 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readV1(r io.Reader) (*Header, error) {
+	line := []byte{'P'}
+	b := make([]byte, 1)
+	for len(line) < v1MaxLen {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return parseV1(string(line[:len(line)-2]))
+		}
+	}
+	return nil, ErrInvalidHeader
+}
+
+func parseV1(line string) (*Header, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return &Header{Version: 1, Local: true}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidHeader
+	}
+	src, err := tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := tcpAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	if (src.IP.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, ErrInvalidHeader
+	}
+	return &Header{Version: 1, Source: src, Dest: dst}, nil
+}
+
+func tcpAddr(host string, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 签名(12) + 版本及命令(1) + 地址族及协议(1) + 地址长度(2) + 地址 + TLV
+func readV2(r io.Reader) (*Header, error) {
+	head := make([]byte, 16)
+	head[0] = v2Signature[0]
+	if _, err := io.ReadFull(r, head[1:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head[:12], v2Signature) || head[12]>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	h := &Header{Version: 2}
+	switch head[12] & 0x0f {
+	case 0x0: // LOCAL
+		h.Local = true
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrInvalidHeader
+	}
+	switch head[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, ErrInvalidHeader
+		}
+		h.Source = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+		h.Dest = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, ErrInvalidHeader
+		}
+		h.Source = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+		h.Dest = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}
+	case 0x00: // UNSPEC
+		h.Local = true
+	default:
+		return nil, ErrUnsupported
+	}
+	return h, nil
+}
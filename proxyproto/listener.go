@@ -0,0 +1,207 @@
+package proxyproto
+
+import (
+	"dusnet/logger"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultHeaderTimeout 读取PROXY协议报文头的超时时间
+const defaultHeaderTimeout = 5 * time.Second
+
+// Listener 解析PROXY协议的监听器，只解析来自可信上游(负载均衡)的连接，
+// 解析在独立协程中完成，不阻塞接受其他连接；来自可信上游但未发送报文头的连接(如健康检查)按原地址处理
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+	conns   chan net.Conn
+	errs    chan error
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Listen 包装l，trusted为可信上游的ip或网段，timeout为读取报文头的超时时间，为0时默认5秒
+func Listen(l net.Listener, trusted []string, timeout time.Duration) (*Listener, error) {
+	prefixes := make([]netip.Prefix, 0, len(trusted))
+	for _, v := range trusted {
+		prefix, err := parsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if timeout <= 0 {
+		timeout = defaultHeaderTimeout
+	}
+	pl := &Listener{
+		Listener: l,
+		trusted:  prefixes,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go pl.accept()
+	return pl, nil
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+func (l *Listener) accept() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if !l.isTrusted(conn.RemoteAddr()) {
+			l.deliver(conn)
+			continue
+		}
+		go func() {
+			pc, err := l.parse(conn)
+			if err != nil {
+				logger.Warn("proxy protocol header from %s error,error:%+v", conn.RemoteAddr(), err)
+				_ = conn.Close()
+				return
+			}
+			l.deliver(pc)
+		}()
+	}
+}
+
+func (l *Listener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+// parse 读取首字节判断是否为PROXY协议报文头，不是时首字节留给后续读取
+func (l *Listener) parse(conn net.Conn) (net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(l.timeout)); err != nil {
+		return nil, err
+	}
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		return nil, err
+	}
+	pc := &Conn{Conn: conn, upstream: conn.RemoteAddr()}
+	if first[0] != 'P' && first[0] != v2Signature[0] {
+		pc.pending = first
+		return pc, conn.SetReadDeadline(time.Time{})
+	}
+	h, err := readHeader(conn, first[0])
+	if err != nil {
+		return nil, err
+	}
+	if !h.Local {
+		pc.source = h.Source
+	}
+	logger.Debug("proxy protocol v%d from %s,client:%v", h.Version, conn.RemoteAddr(), h.Source)
+	return pc, conn.SetReadDeadline(time.Time{})
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn 经PROXY协议转发的连接，RemoteAddr返回真实客户端地址
+type Conn struct {
+	net.Conn
+	upstream net.Addr // 负载均衡地址
+	source   net.Addr // 真实客户端地址，为空时使用连接本身的地址
+	pending  []byte   // 判断是否为报文头时多读的首字节
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// UpstreamAddr 负载均衡的地址
+func (c *Conn) UpstreamAddr() net.Addr {
+	return c.upstream
+}
+
+// SyscallConn 透传底层套接字，供reactor使用；仍有未读取的首字节时不可绕过缓冲直接读取套接字
+func (c *Conn) SyscallConn() (syscall.RawConn, error) {
+	if len(c.pending) > 0 {
+		return nil, errors.New("connection has buffered data")
+	}
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("connection does not expose raw socket")
+	}
+	return sc.SyscallConn()
+}
+
+func parsePrefix(v string) (netip.Prefix, error) {
+	v = strings.TrimSpace(v)
+	if strings.Contains(v, "/") {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return prefix, errors.New(fmt.Sprintf("invalid cidr %q,error:%v", v, err))
+		}
+		return prefix.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(v)
+	if err != nil {
+		return netip.Prefix{}, errors.New(fmt.Sprintf("invalid ip %q,error:%v", v, err))
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
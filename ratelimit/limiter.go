@@ -0,0 +1,288 @@
+package ratelimit
+
+import (
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	ScopeConn   = "conn"   // 每个连接
+	ScopeDevice = "device" // 每个设备，未绑定设备的连接不限制
+	ScopeIP     = "ip"     // 每个源ip
+	ScopeRoute  = "route"  // 每个路由，所有连接共享
+)
+
+const (
+	ActionDelay      = "delay"      // 延迟处理，阻塞该连接的读取直到有令牌，超过MaxDelay时丢弃；DisableDelay后按drop处理
+	ActionDrop       = "drop"       // 丢弃报文
+	ActionReply      = "reply"      // 丢弃报文并回复错误报文
+	ActionDisconnect = "disconnect" // 断开连接
+)
+
+// defaultMaxDelay 延迟处理的最长等待时间
+const defaultMaxDelay = time.Second
+
+// sweepInterval 回收空闲令牌桶的间隔
+const sweepInterval = time.Minute
+
+// Limit 限流规则
+type Limit struct {
+	// Scope 限流维度 conn/device/ip/route
+	Scope string `json:"scope" yaml:"scope"`
+	// Routes 只限制这些路由的报文，为空时限制所有报文
+	Routes []uint32 `json:"routes" yaml:"routes"`
+	// Rate 每秒允许的报文数
+	Rate float64 `json:"rate" yaml:"rate"`
+	// Burst 允许的突发报文数
+	Burst int `json:"burst" yaml:"burst"`
+	// Action 超过限制时的动作 delay/drop/reply/disconnect
+	Action string `json:"action" yaml:"action"`
+	// MaxDelay delay动作的最长等待时间，默认1秒
+	MaxDelay time.Duration `json:"maxdelay" yaml:"maxdelay"`
+}
+
+// Config 限流配置，对应config.yml中的ratelimitconfig
+type Config struct {
+	Limits []Limit `json:"limits" yaml:"limits"`
+}
+
+// Stat 限流规则的统计
+type Stat struct {
+	Scope     string   `json:"scope"`
+	Routes    []uint32 `json:"routes"`
+	Action    string   `json:"action"`
+	Buckets   int      `json:"buckets"`   // 当前令牌桶数
+	Passed    uint64   `json:"passed"`    // 放行的报文数
+	Delayed   uint64   `json:"delayed"`   // 延迟后放行的报文数
+	Throttled uint64   `json:"throttled"` // 被限制(丢弃、回复错误或断开)的报文数
+}
+
+// Decision 限流结果
+type Decision struct {
+	Allowed bool          // 是否放行
+	Wait    time.Duration // 放行前需等待的时间
+	Action  string        // 未放行时的动作
+}
+
+// LoadConfig 从config/config.yml的ratelimitconfig读取限流配置
+func LoadConfig() (Config, error) {
+	conf := Config{}
+	vp := viper.New()
+	vp.AddConfigPath("config")
+	vp.SetConfigName("config.yml")
+	vp.SetConfigType("yml")
+	if err := vp.ReadInConfig(); err != nil {
+		return conf, err
+	}
+	if !vp.IsSet("ratelimitconfig") {
+		return conf, errors.New("ratelimitconfig not found in config.yml")
+	}
+	err := vp.UnmarshalKey("ratelimitconfig", &conf)
+	return conf, err
+}
+
+// Limiter 按连接、设备、源ip、路由限流
+type Limiter struct {
+	connMgr connect.IConnectionMgr
+	limits  []*limit
+	noDelay bool // delay动作按drop处理
+	stop    chan struct{}
+	once    sync.Once
+}
+
+type limit struct {
+	Limit
+	lock      sync.Mutex
+	buckets   map[string]*Bucket
+	passed    uint64
+	delayed   uint64
+	throttled uint64
+}
+
+// New 返回按conf限流的限流器，connMgr用于查询连接绑定的设备
+func New(conf Config, connMgr connect.IConnectionMgr) (*Limiter, error) {
+	l := &Limiter{connMgr: connMgr, stop: make(chan struct{})}
+	for _, c := range conf.Limits {
+		switch c.Scope {
+		case ScopeConn, ScopeDevice, ScopeIP, ScopeRoute:
+		default:
+			return nil, errors.New(fmt.Sprintf("unknown rate limit scope %q", c.Scope))
+		}
+		switch c.Action {
+		case ActionDelay, ActionDrop, ActionReply, ActionDisconnect:
+		default:
+			return nil, errors.New(fmt.Sprintf("unknown rate limit action %q", c.Action))
+		}
+		if c.Rate <= 0 {
+			return nil, errors.New("rate limit rate must be positive")
+		}
+		if c.MaxDelay <= 0 {
+			c.MaxDelay = defaultMaxDelay
+		}
+		l.limits = append(l.limits, &limit{Limit: c, buckets: make(map[string]*Bucket)})
+	}
+	go l.sweep()
+	return l, nil
+}
+
+// SetConnMgr 设置连接管理器
+func (l *Limiter) SetConnMgr(connMgr connect.IConnectionMgr) {
+	l.connMgr = connMgr
+}
+
+// DisableDelay delay动作不再等待令牌，超过限制时按drop处理；
+// 报文在共享的处理协程中检查时等待会阻塞其他连接，需在开始检查前调用
+func (l *Limiter) DisableDelay() {
+	l.noDelay = true
+}
+
+// Check 检查conn发送的pkt是否超过限制，按规则顺序检查，第一条超过的规则决定动作
+func (l *Limiter) Check(conn connect.IConnection, pkt packet.IPacket) Decision {
+	var wait time.Duration
+	for _, lim := range l.limits {
+		key, ok := l.key(lim, conn, pkt)
+		if !ok {
+			continue
+		}
+		b := lim.bucket(key)
+		if lim.Action == ActionDelay && !l.noDelay {
+			w, ok := b.Reserve(lim.MaxDelay)
+			if !ok {
+				atomic.AddUint64(&lim.throttled, 1)
+				return Decision{Action: ActionDrop}
+			}
+			if w > 0 {
+				atomic.AddUint64(&lim.delayed, 1)
+			} else {
+				atomic.AddUint64(&lim.passed, 1)
+			}
+			if w > wait {
+				wait = w
+			}
+			continue
+		}
+		if !b.Take() {
+			atomic.AddUint64(&lim.throttled, 1)
+			if lim.Action == ActionDelay {
+				return Decision{Action: ActionDrop}
+			}
+			return Decision{Action: lim.Action}
+		}
+		atomic.AddUint64(&lim.passed, 1)
+	}
+	return Decision{Allowed: true, Wait: wait}
+}
+
+// Forget 连接释放时回收其令牌桶
+func (l *Limiter) Forget(conn connect.IConnection) {
+	key := strconv.FormatUint(conn.GetID(), 10)
+	for _, lim := range l.limits {
+		if lim.Scope == ScopeConn {
+			lim.lock.Lock()
+			delete(lim.buckets, key)
+			lim.lock.Unlock()
+		}
+	}
+}
+
+// Stats 返回各规则的统计
+func (l *Limiter) Stats() []Stat {
+	stats := make([]Stat, 0, len(l.limits))
+	for _, lim := range l.limits {
+		lim.lock.Lock()
+		buckets := len(lim.buckets)
+		lim.lock.Unlock()
+		stats = append(stats, Stat{
+			Scope:     lim.Scope,
+			Routes:    lim.Routes,
+			Action:    lim.Action,
+			Buckets:   buckets,
+			Passed:    atomic.LoadUint64(&lim.passed),
+			Delayed:   atomic.LoadUint64(&lim.delayed),
+			Throttled: atomic.LoadUint64(&lim.throttled),
+		})
+	}
+	return stats
+}
+
+// Stop 停止回收空闲令牌桶
+func (l *Limiter) Stop() {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+}
+
+func (l *Limiter) key(lim *limit, conn connect.IConnection, pkt packet.IPacket) (string, bool) {
+	if len(lim.Routes) > 0 {
+		matched := false
+		for _, id := range lim.Routes {
+			if id == pkt.GetID() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "", false
+		}
+	}
+	switch lim.Scope {
+	case ScopeConn:
+		return strconv.FormatUint(conn.GetID(), 10), true
+	case ScopeDevice:
+		if l.connMgr == nil {
+			return "", false
+		}
+		device := connect.DeviceOf(l.connMgr, conn.GetID())
+		return device, device != ""
+	case ScopeIP:
+		return conn.GetRemoteHost(), true
+	case ScopeRoute:
+		return strconv.FormatUint(uint64(pkt.GetID()), 10), true
+	}
+	return "", false
+}
+
+func (lim *limit) bucket(key string) *Bucket {
+	lim.lock.Lock()
+	defer lim.lock.Unlock()
+	b, ok := lim.buckets[key]
+	if !ok {
+		b = NewBucket(lim.Rate, lim.Burst)
+		lim.buckets[key] = b
+	}
+	return b
+}
+
+// sweep 定期回收已补满的令牌桶，避免源ip及设备维度的令牌桶无限增长
+func (l *Limiter) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			removed := 0
+			for _, lim := range l.limits {
+				lim.lock.Lock()
+				for key, b := range lim.buckets {
+					if b.idle(now) {
+						delete(lim.buckets, key)
+						removed++
+					}
+				}
+				lim.lock.Unlock()
+			}
+			logger.Debug("rate limiter swept %d idle buckets", removed)
+		}
+	}
+}
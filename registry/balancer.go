@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"dusnet/logger"
+	"sync"
+)
+
+// Balancer 客户端负载均衡器，监听注册中心中的服务实例并轮询选择
+type Balancer struct {
+	name      string
+	watcher   Watcher
+	lock      sync.RWMutex
+	instances []*ServiceInstance
+	next      uint64
+}
+
+// NewBalancer 监听reg中名称为name的服务，返回对应的负载均衡器
+func NewBalancer(reg Registry, name string) (*Balancer, error) {
+	watcher, err := reg.Watch(name)
+	if err != nil {
+		return nil, err
+	}
+	b := &Balancer{name: name, watcher: watcher}
+	// 首次Next立即返回，保证构造完成后即可选择实例
+	instances, err := watcher.Next()
+	if err != nil {
+		_ = watcher.Stop()
+		return nil, err
+	}
+	b.update(instances)
+	go b.watch()
+	return b, nil
+}
+
+// Pick 轮询选择一个服务实例
+func (b *Balancer) Pick() (*ServiceInstance, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.instances) == 0 {
+		return nil, ErrNoInstance
+	}
+	ins := b.instances[b.next%uint64(len(b.instances))]
+	b.next++
+	return ins, nil
+}
+
+// Instances 返回当前可用的服务实例
+func (b *Balancer) Instances() []*ServiceInstance {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.instances
+}
+
+// Stop 停止监听
+func (b *Balancer) Stop() error {
+	return b.watcher.Stop()
+}
+
+func (b *Balancer) watch() {
+	for {
+		instances, err := b.watcher.Next()
+		if err != nil {
+			if err != ErrWatcherStopped {
+				logger.Error("balancer[%s] watch error,error:%+v", b.name, err)
+			}
+			return
+		}
+		b.update(instances)
+	}
+}
+
+func (b *Balancer) update(instances []*ServiceInstance) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.instances = instances
+	logger.Debug("balancer[%s] instances updated,size:%d", b.name, len(instances))
+}
@@ -0,0 +1,282 @@
+package registry
+
+import (
+	"context"
+	"dusnet/logger"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	defaultEtcdPrefix = "/dusnet/services"
+	defaultEtcdTTL    = 10 // 秒
+)
+
+// EtcdClient etcd registry依赖的客户端能力，*clientv3.Client实现了此接口，测试时也可替换为本地实现
+type EtcdClient interface {
+	clientv3.KV
+	clientv3.Lease
+	clientv3.Watcher
+}
+
+// EtcdOption etcd registry可选配置
+type EtcdOption func(*etcdRegistry)
+
+// WithEtcdPrefix 设置服务注册的key前缀，默认/dusnet/services
+func WithEtcdPrefix(prefix string) EtcdOption {
+	return func(r *etcdRegistry) {
+		r.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithEtcdTTL 设置租约有效期，节点宕机后最长ttl时间内从注册中心消失，默认10秒
+func WithEtcdTTL(ttl time.Duration) EtcdOption {
+	return func(r *etcdRegistry) {
+		r.ttl = int64(ttl / time.Second)
+	}
+}
+
+// etcdRegistry etcd v3注册中心适配器
+// 每个实例以 prefix/服务名称/实例id 为key写入，key绑定租约并持续续约
+type etcdRegistry struct {
+	client EtcdClient
+	prefix string
+	ttl    int64
+	lock   sync.Mutex
+	leases map[string]*etcdLease // key -> 租约
+}
+
+type etcdLease struct {
+	id     clientv3.LeaseID
+	cancel context.CancelFunc // 停止续约
+}
+
+// NewEtcd 返回etcd注册中心实现
+func NewEtcd(client EtcdClient, opts ...EtcdOption) Registry {
+	r := &etcdRegistry{
+		client: client,
+		prefix: defaultEtcdPrefix,
+		ttl:    defaultEtcdTTL,
+		leases: make(map[string]*etcdLease),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.ttl <= 0 {
+		r.ttl = defaultEtcdTTL
+	}
+	return r
+}
+
+func (r *etcdRegistry) serviceKey(name string) string {
+	return r.prefix + "/" + name + "/"
+}
+
+func (r *etcdRegistry) instanceKey(ins *ServiceInstance) string {
+	return r.serviceKey(ins.Name) + ins.ID
+}
+
+func (r *etcdRegistry) Register(ins *ServiceInstance) error {
+	if ins == nil || ins.Name == "" || ins.ID == "" {
+		return errors.New("service instance name and id must not be empty")
+	}
+	value, err := json.Marshal(ins)
+	if err != nil {
+		return err
+	}
+	key := r.instanceKey(ins)
+	// 租约相关请求不持有锁，etcd阻塞时不影响其他实例的注册及注销
+	r.lock.Lock()
+	old, ok := r.leases[key]
+	delete(r.leases, key)
+	r.lock.Unlock()
+	if ok {
+		// 重复注册视为更新，先释放旧租约
+		r.revoke(old)
+	}
+	lease, err := r.grant(key, string(value))
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	replaced := r.leases[key]
+	r.leases[key] = lease
+	r.lock.Unlock()
+	if replaced != nil {
+		// 并发注册同一实例时以后完成的为准
+		r.revoke(replaced)
+	}
+	return nil
+}
+
+// grant 申请租约、写入key并开始续约
+func (r *etcdRegistry) grant(key string, value string) (*etcdLease, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.ttl)*time.Second)
+	defer cancel()
+	grant, err := r.client.Grant(ctx, r.ttl)
+	if err != nil {
+		logger.Error("etcd grant lease error,error:%+v", err)
+		return nil, err
+	}
+	if _, err := r.client.Put(ctx, key, value, clientv3.WithLease(grant.ID)); err != nil {
+		logger.Error("etcd put key[%s] error,error:%+v", key, err)
+		return nil, err
+	}
+	keepCtx, keepCancel := context.WithCancel(context.Background())
+	ch, err := r.client.KeepAlive(keepCtx, grant.ID)
+	if err != nil {
+		keepCancel()
+		logger.Error("etcd keepalive lease[%d] error,error:%+v", grant.ID, err)
+		return nil, err
+	}
+	lease := &etcdLease{id: grant.ID, cancel: keepCancel}
+	go r.keepAlive(keepCtx, key, value, lease, ch)
+	return lease, nil
+}
+
+// keepAlive 消费续约应答，续约通道意外关闭(租约过期或etcd不可达)时重新注册
+func (r *etcdRegistry) keepAlive(ctx context.Context, key string, value string, lease *etcdLease, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for range ch {
+	}
+	for {
+		if ctx.Err() != nil {
+			// 已注销
+			return
+		}
+		logger.Warn("etcd lease[%d] of key[%s] lost,re-registering", lease.id, key)
+		if !r.current(key, lease) {
+			return
+		}
+		renewed, err := r.grant(key, value)
+		if err == nil {
+			r.lock.Lock()
+			current := r.leases[key] == lease
+			if current {
+				r.leases[key] = renewed
+			}
+			r.lock.Unlock()
+			if !current {
+				// 重新申请期间已注销或重新注册
+				r.revoke(renewed)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// current key当前是否仍使用lease
+func (r *etcdRegistry) current(key string, lease *etcdLease) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.leases[key] == lease
+}
+
+func (r *etcdRegistry) revoke(lease *etcdLease) {
+	lease.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.ttl)*time.Second)
+	defer cancel()
+	// 撤销租约时etcd会一并删除绑定的key
+	if _, err := r.client.Revoke(ctx, lease.id); err != nil {
+		logger.Warn("etcd revoke lease[%d] error,error:%+v", lease.id, err)
+	}
+}
+
+func (r *etcdRegistry) Deregister(ins *ServiceInstance) error {
+	if ins == nil {
+		return nil
+	}
+	key := r.instanceKey(ins)
+	r.lock.Lock()
+	lease, ok := r.leases[key]
+	delete(r.leases, key)
+	r.lock.Unlock()
+	if ok {
+		r.revoke(lease)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.ttl)*time.Second)
+	defer cancel()
+	_, err := r.client.Delete(ctx, key)
+	return err
+}
+
+func (r *etcdRegistry) List(name string) ([]*ServiceInstance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.ttl)*time.Second)
+	defer cancel()
+	resp, err := r.client.Get(ctx, r.serviceKey(name), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]*ServiceInstance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		ins := &ServiceInstance{}
+		if err := json.Unmarshal(kv.Value, ins); err != nil {
+			logger.Warn("etcd key[%s] with invalid instance,error:%+v", string(kv.Key), err)
+			continue
+		}
+		instances = append(instances, ins)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances, nil
+}
+
+func (r *etcdRegistry) Watch(name string) (Watcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &etcdWatcher{
+		name:   name,
+		reg:    r,
+		ctx:    ctx,
+		cancel: cancel,
+		ch:     r.client.Watch(ctx, r.serviceKey(name), clientv3.WithPrefix()),
+		first:  true,
+	}, nil
+}
+
+type etcdWatcher struct {
+	name   string
+	reg    *etcdRegistry
+	ctx    context.Context
+	cancel context.CancelFunc
+	ch     clientv3.WatchChan
+	first  bool
+}
+
+func (w *etcdWatcher) Next() ([]*ServiceInstance, error) {
+	if w.first {
+		w.first = false
+		return w.reg.List(w.name)
+	}
+	select {
+	case <-w.ctx.Done():
+		return nil, ErrWatcherStopped
+	case resp, ok := <-w.ch:
+		if !ok {
+			if w.ctx.Err() != nil {
+				return nil, ErrWatcherStopped
+			}
+			return nil, errors.New("etcd watch channel closed")
+		}
+		if err := resp.Err(); err != nil {
+			return nil, err
+		}
+		// 事件只作为变更通知，以全量列表为准，避免维护增量状态
+		return w.reg.List(w.name)
+	}
+}
+
+func (w *etcdWatcher) Stop() error {
+	w.cancel()
+	return nil
+}
@@ -0,0 +1,314 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeEtcd 本地实现的etcd客户端，按etcd语义提供KV、租约及前缀监听，租约只在expire时过期
+type fakeEtcd struct {
+	clientv3.KV // 未使用的方法调用时panic
+	clientv3.Lease
+	clientv3.Watcher
+
+	lock     sync.Mutex
+	rev      int64
+	nextID   clientv3.LeaseID
+	kvs      map[string]fakeKV
+	leases   map[clientv3.LeaseID][]chan *clientv3.LeaseKeepAliveResponse // 租约 -> 续约应答通道
+	watchers []*fakeWatch
+	grants   int
+}
+
+type fakeKV struct {
+	value string
+	lease clientv3.LeaseID
+}
+
+type fakeWatch struct {
+	prefix string
+	ch     chan clientv3.WatchResponse
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		kvs:    make(map[string]fakeKV),
+		leases: make(map[clientv3.LeaseID][]chan *clientv3.LeaseKeepAliveResponse),
+	}
+}
+
+// leaseOf clientv3.Op未导出租约id，通过反射读取
+func leaseOf(op clientv3.Op) clientv3.LeaseID {
+	return clientv3.LeaseID(reflect.ValueOf(op).FieldByName("leaseID").Int())
+}
+
+func (f *fakeEtcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	lease := leaseOf(clientv3.OpPut(key, val, opts...))
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.leases[lease]; lease != 0 && !ok {
+		return nil, errors.New("lease not found")
+	}
+	f.kvs[key] = fakeKV{value: val, lease: lease}
+	f.notify(key, clientv3.EventTypePut)
+	return &clientv3.PutResponse{}, nil
+}
+
+func (f *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	resp := &clientv3.GetResponse{}
+	for k, kv := range f.kvs {
+		if k == key || (len(op.RangeBytes()) > 0 && strings.HasPrefix(k, key)) {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(kv.value), Lease: int64(kv.lease)})
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEtcd) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.kvs[key]; ok {
+		delete(f.kvs, key)
+		f.notify(key, clientv3.EventTypeDelete)
+	}
+	return &clientv3.DeleteResponse{}, nil
+}
+
+func (f *fakeEtcd) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.nextID++
+	f.grants++
+	f.leases[f.nextID] = nil
+	return &clientv3.LeaseGrantResponse{ID: f.nextID, TTL: ttl}, nil
+}
+
+func (f *fakeEtcd) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	f.expire(id)
+	return &clientv3.LeaseRevokeResponse{}, nil
+}
+
+func (f *fakeEtcd) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	chs, ok := f.leases[id]
+	if !ok {
+		return nil, errors.New("lease not found")
+	}
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 1)
+	f.leases[id] = append(chs, ch)
+	ch <- &clientv3.LeaseKeepAliveResponse{ID: id}
+	go func() {
+		<-ctx.Done()
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		chs := f.leases[id]
+		for i, c := range chs {
+			if c == ch {
+				f.leases[id] = append(chs[:i], chs[i+1:]...)
+				close(ch)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (f *fakeEtcd) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	w := &fakeWatch{prefix: key, ch: make(chan clientv3.WatchResponse, 16)}
+	f.lock.Lock()
+	f.watchers = append(f.watchers, w)
+	f.lock.Unlock()
+	go func() {
+		<-ctx.Done()
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		for i, c := range f.watchers {
+			if c == w {
+				f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+				close(w.ch)
+			}
+		}
+	}()
+	return w.ch
+}
+
+func (f *fakeEtcd) Close() error {
+	return nil
+}
+
+// expire 租约过期，删除绑定的key并关闭续约通道
+func (f *fakeEtcd) expire(id clientv3.LeaseID) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	chs, ok := f.leases[id]
+	if !ok {
+		return
+	}
+	delete(f.leases, id)
+	for _, ch := range chs {
+		close(ch)
+	}
+	for k, kv := range f.kvs {
+		if kv.lease == id {
+			delete(f.kvs, k)
+			f.notify(k, clientv3.EventTypeDelete)
+		}
+	}
+}
+
+// notify 调用方需持有锁
+func (f *fakeEtcd) notify(key string, typ mvccpb.Event_EventType) {
+	f.rev++
+	for _, w := range f.watchers {
+		if strings.HasPrefix(key, w.prefix) {
+			ev := &clientv3.Event{Type: typ, Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: f.rev}}
+			w.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{ev}}
+		}
+	}
+}
+
+// leaseOfKey key当前绑定的租约，key不存在时为0
+func (f *fakeEtcd) leaseOfKey(key string) clientv3.LeaseID {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.kvs[key].lease
+}
+
+func testInstance(id string, port int) *ServiceInstance {
+	return &ServiceInstance{ID: id, Name: "dusnet", Network: "tcp", Host: "127.0.0.1", Port: port}
+}
+
+func ids(instances []*ServiceInstance) []string {
+	res := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		res = append(res, ins.ID)
+	}
+	return res
+}
+
+func TestEtcdRegisterDeregister(t *testing.T) {
+	f := newFakeEtcd()
+	reg := NewEtcd(f, WithEtcdPrefix("/test/"))
+	if err := reg.Register(testInstance("n1", 9000)); err != nil {
+		t.Fatalf("register error:%v", err)
+	}
+	if err := reg.Register(testInstance("n2", 9001)); err != nil {
+		t.Fatalf("register error:%v", err)
+	}
+	if f.leaseOfKey("/test/dusnet/n1") == 0 {
+		t.Fatalf("instance key not bound to a lease")
+	}
+	instances, err := reg.List("dusnet")
+	if err != nil || !reflect.DeepEqual(ids(instances), []string{"n1", "n2"}) {
+		t.Fatalf("list got %v,%v", ids(instances), err)
+	}
+	if instances[0].Address() != "127.0.0.1:9000" {
+		t.Fatalf("address got %s", instances[0].Address())
+	}
+	if err := reg.Deregister(testInstance("n1", 9000)); err != nil {
+		t.Fatalf("deregister error:%v", err)
+	}
+	instances, _ = reg.List("dusnet")
+	if !reflect.DeepEqual(ids(instances), []string{"n2"}) {
+		t.Fatalf("list after deregister got %v", ids(instances))
+	}
+}
+
+func TestEtcdKeepAliveRegrant(t *testing.T) {
+	f := newFakeEtcd()
+	reg := NewEtcd(f)
+	if err := reg.Register(testInstance("n1", 9000)); err != nil {
+		t.Fatalf("register error:%v", err)
+	}
+	key := defaultEtcdPrefix + "/dusnet/n1"
+	lost := f.leaseOfKey(key)
+	f.expire(lost)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if lease := f.leaseOfKey(key); lease != 0 && lease != lost {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instance not re-registered after lease loss")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 注销后不再重新注册
+	if err := reg.Deregister(testInstance("n1", 9000)); err != nil {
+		t.Fatalf("deregister error:%v", err)
+	}
+	grants := func() int {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return f.grants
+	}
+	before := grants()
+	time.Sleep(100 * time.Millisecond)
+	if f.leaseOfKey(key) != 0 || grants() != before {
+		t.Fatalf("deregistered instance re-registered")
+	}
+}
+
+func TestEtcdWatch(t *testing.T) {
+	f := newFakeEtcd()
+	reg := NewEtcd(f)
+	w, err := reg.Watch("dusnet")
+	if err != nil {
+		t.Fatalf("watch error:%v", err)
+	}
+	defer w.Stop()
+	next := func() []string {
+		ch := make(chan []string, 1)
+		go func() {
+			instances, err := w.Next()
+			if err != nil {
+				t.Errorf("next error:%v", err)
+			}
+			ch <- ids(instances)
+		}()
+		select {
+		case res := <-ch:
+			return res
+		case <-time.After(2 * time.Second):
+			t.Fatalf("watch next timeout")
+			return nil
+		}
+	}
+	if got := next(); len(got) != 0 {
+		t.Fatalf("initial list got %v", got)
+	}
+	if err := reg.Register(testInstance("n1", 9000)); err != nil {
+		t.Fatalf("register error:%v", err)
+	}
+	if got := next(); !reflect.DeepEqual(got, []string{"n1"}) {
+		t.Fatalf("after add got %v", got)
+	}
+	// 其他服务的变化不通知
+	if err := reg.Register(&ServiceInstance{ID: "x", Name: "other"}); err != nil {
+		t.Fatalf("register error:%v", err)
+	}
+	if err := reg.Deregister(testInstance("n1", 9000)); err != nil {
+		t.Fatalf("deregister error:%v", err)
+	}
+	// 撤销租约及删除key各产生一个删除事件，取最终状态
+	if got := next(); len(got) != 0 {
+		t.Fatalf("after delete got %v", got)
+	}
+	if err := w.Stop(); err != nil {
+		t.Fatalf("stop error:%v", err)
+	}
+	if _, err := w.Next(); !errors.Is(err, ErrWatcherStopped) {
+		t.Fatalf("next after stop got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// memoryRegistry 进程内注册中心实现，同时作为dusnet节点对外提供注册中心服务时的存储
+type memoryRegistry struct {
+	lock     sync.RWMutex
+	services map[string]map[string]*ServiceInstance // 服务名称 -> 实例id -> 实例
+	watchers map[string]map[*memoryWatcher]struct{} // 服务名称 -> 监听器
+}
+
+// NewMemory 返回进程内注册中心实现
+func NewMemory() Registry {
+	return &memoryRegistry{
+		services: make(map[string]map[string]*ServiceInstance),
+		watchers: make(map[string]map[*memoryWatcher]struct{}),
+	}
+}
+
+func (r *memoryRegistry) Register(ins *ServiceInstance) error {
+	if ins == nil || ins.Name == "" || ins.ID == "" {
+		return errors.New("service instance name and id must not be empty")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	instances, ok := r.services[ins.Name]
+	if !ok {
+		instances = make(map[string]*ServiceInstance)
+		r.services[ins.Name] = instances
+	}
+	instances[ins.ID] = ins
+	r.notify(ins.Name)
+	return nil
+}
+
+func (r *memoryRegistry) Deregister(ins *ServiceInstance) error {
+	if ins == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	instances, ok := r.services[ins.Name]
+	if !ok {
+		return nil
+	}
+	if _, ok := instances[ins.ID]; !ok {
+		return nil
+	}
+	delete(instances, ins.ID)
+	if len(instances) == 0 {
+		delete(r.services, ins.Name)
+	}
+	r.notify(ins.Name)
+	return nil
+}
+
+func (r *memoryRegistry) List(name string) ([]*ServiceInstance, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.list(name), nil
+}
+
+func (r *memoryRegistry) list(name string) []*ServiceInstance {
+	instances := make([]*ServiceInstance, 0, len(r.services[name]))
+	for _, ins := range r.services[name] {
+		instances = append(instances, ins)
+	}
+	// 保证同一时刻不同调用方拿到的顺序一致，便于负载均衡
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances
+}
+
+func (r *memoryRegistry) Watch(name string) (Watcher, error) {
+	w := &memoryWatcher{
+		name:    name,
+		reg:     r,
+		changed: make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+	// 首次Next立即返回当前列表
+	w.changed <- struct{}{}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	watchers, ok := r.watchers[name]
+	if !ok {
+		watchers = make(map[*memoryWatcher]struct{})
+		r.watchers[name] = watchers
+	}
+	watchers[w] = struct{}{}
+	return w, nil
+}
+
+// notify 通知监听该服务的所有监听器，调用方需持有写锁
+func (r *memoryRegistry) notify(name string) {
+	for w := range r.watchers[name] {
+		select {
+		case w.changed <- struct{}{}:
+		default:
+			// 已有未消费的变更通知，合并即可
+		}
+	}
+}
+
+func (r *memoryRegistry) removeWatcher(w *memoryWatcher) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if watchers, ok := r.watchers[w.name]; ok {
+		delete(watchers, w)
+		if len(watchers) == 0 {
+			delete(r.watchers, w.name)
+		}
+	}
+}
+
+type memoryWatcher struct {
+	name     string
+	reg      *memoryRegistry
+	changed  chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (w *memoryWatcher) Next() ([]*ServiceInstance, error) {
+	select {
+	case <-w.stopped:
+		return nil, ErrWatcherStopped
+	case <-w.changed:
+		return w.reg.List(w.name)
+	}
+}
+
+func (w *memoryWatcher) Stop() error {
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+		w.reg.removeWatcher(w)
+	})
+	return nil
+}
@@ -0,0 +1,42 @@
+package registry
+
+// 内置注册中心在dusnet协议上的请求类型，请求及应答均以json编码后作为包体
+const (
+	OpRegister   = "register"   // 注册实例
+	OpDeregister = "deregister" // 注销实例
+	OpList       = "list"       // 获取实例列表
+	OpWatch      = "watch"      // 监听实例变化，之后每次变化时服务端主动推送最新实例列表
+)
+
+// Request 注册中心请求
+type Request struct {
+	Op       string           `json:"op"`
+	Name     string           `json:"name,omitempty"`
+	Instance *ServiceInstance `json:"instance,omitempty"`
+}
+
+// Response 注册中心应答
+type Response struct {
+	Instances []*ServiceInstance `json:"instances,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// Serve 使用reg处理除watch外的注册中心请求
+func Serve(reg Registry, req *Request) *Response {
+	var err error
+	resp := &Response{}
+	switch req.Op {
+	case OpRegister:
+		err = reg.Register(req.Instance)
+	case OpDeregister:
+		err = reg.Deregister(req.Instance)
+	case OpList:
+		resp.Instances, err = reg.List(req.Name)
+	default:
+		resp.Error = "registry op " + req.Op + " not supported"
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+// RouteID 内置注册中心在dusnet协议中使用的路由id
+const RouteID uint32 = 4000
+
+var (
+	ErrWatcherStopped = errors.New("registry watcher stopped")
+	ErrNoInstance     = errors.New("no available service instance")
+)
+
+// ServiceInstance 服务实例，一个mServer对应一个实例
+type ServiceInstance struct {
+	ID       string            `json:"id"`                 // 实例id，同一服务下唯一
+	Name     string            `json:"name"`               // 服务名称
+	Network  string            `json:"network"`            // 网络
+	Host     string            `json:"host"`               // host
+	Port     int               `json:"port"`               // 端口
+	Metadata map[string]string `json:"metadata,omitempty"` // 扩展信息
+}
+
+// Address 返回实例的 host:port 地址
+func (s *ServiceInstance) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Registry 注册中心抽象，内置实现及第三方注册中心(etcd、zookeeper)适配器均实现此接口
+type Registry interface {
+	Register(ins *ServiceInstance) error          // 注册实例
+	Deregister(ins *ServiceInstance) error        // 注销实例
+	List(name string) ([]*ServiceInstance, error) // 根据服务名称获取实例列表
+	Watch(name string) (Watcher, error)           // 根据服务名称监听实例变化
+}
+
+// Watcher 服务实例监听器
+type Watcher interface {
+	Next() ([]*ServiceInstance, error) // 首次调用立即返回当前实例列表，之后阻塞直到实例列表发生变化
+	Stop() error                       // 停止监听
+}
@@ -0,0 +1,162 @@
+package registry
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// callTimeout 请求等待应答的超时时间，超时后关闭连接使阻塞的读取返回
+const callTimeout = 5 * time.Second
+
+// remoteRegistry 通过dusnet协议访问另一个dusnet节点提供的内置注册中心
+type remoteRegistry struct {
+	network string
+	address string
+	codec0  zcodec.Icodec
+	lock    sync.Mutex          // 保证同一连接上请求应答一一对应
+	conn    connect.IConnection // 请求应答连接，懒加载，出错后重建
+}
+
+// NewRemote 返回远程注册中心实现，address为提供注册中心服务的dusnet节点地址
+func NewRemote(network string, address string) Registry {
+	return &remoteRegistry{
+		network: network,
+		address: address,
+		codec0:  zcodec.Default(),
+	}
+}
+
+func (r *remoteRegistry) Register(ins *ServiceInstance) error {
+	_, err := r.call(&Request{Op: OpRegister, Instance: ins})
+	return err
+}
+
+func (r *remoteRegistry) Deregister(ins *ServiceInstance) error {
+	_, err := r.call(&Request{Op: OpDeregister, Instance: ins})
+	return err
+}
+
+func (r *remoteRegistry) List(name string) ([]*ServiceInstance, error) {
+	resp, err := r.call(&Request{Op: OpList, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Instances, nil
+}
+
+func (r *remoteRegistry) Watch(name string) (Watcher, error) {
+	// 每个监听器独占一条连接，服务端在该连接上推送变化
+	conn, err := connect.Dial(r.network, r.address)
+	if err != nil {
+		logger.Error("dial registry[%s] error,error:%+v", r.address, err)
+		return nil, err
+	}
+	if err := send(r.codec0, conn, &Request{Op: OpWatch, Name: name}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &remoteWatcher{codec0: r.codec0, conn: conn, stopped: make(chan struct{})}, nil
+}
+
+func (r *remoteRegistry) call(req *Request) (*Response, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.conn == nil || !r.conn.Alive() {
+		conn, err := connect.Dial(r.network, r.address)
+		if err != nil {
+			logger.Error("dial registry[%s] error,error:%+v", r.address, err)
+			return nil, err
+		}
+		r.conn = conn
+	}
+	resp, err := r.roundTrip(req)
+	if err != nil {
+		// 连接状态未知，丢弃后下次重建
+		_ = r.conn.Close()
+		r.conn = nil
+		return nil, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func (r *remoteRegistry) roundTrip(req *Request) (*Response, error) {
+	conn := r.conn
+	timer := connect.DefaultTimingWheel().AfterFunc(callTimeout, func() {
+		logger.Warn("registry[%s] call %s timeout", r.address, req.Op)
+		go conn.Close()
+	})
+	defer timer.Stop()
+	if err := send(r.codec0, r.conn, req); err != nil {
+		return nil, err
+	}
+	return receive(r.codec0, r.conn)
+}
+
+type remoteWatcher struct {
+	codec0   zcodec.Icodec
+	conn     connect.IConnection
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (w *remoteWatcher) Next() ([]*ServiceInstance, error) {
+	resp, err := receive(w.codec0, w.conn)
+	if err != nil {
+		select {
+		case <-w.stopped:
+			return nil, ErrWatcherStopped
+		default:
+			return nil, err
+		}
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Instances, nil
+}
+
+func (w *remoteWatcher) Stop() error {
+	var err error
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+		err = w.conn.Close()
+	})
+	return err
+}
+
+func send(c zcodec.Icodec, conn connect.IConnection, req *Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	pkt := &packet.Packet{}
+	pkt.ID = RouteID
+	pkt.Type = zcodec.TYPE_BUSINESS
+	pkt.Data = data
+	buf, err := c.Encode(pkt)
+	if err != nil {
+		return err
+	}
+	return conn.Write(buf)
+}
+
+func receive(c zcodec.Icodec, conn connect.IConnection) (*Response, error) {
+	pkt, err := c.Decode(conn)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{}
+	if err := json.Unmarshal(pkt.GetData(), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,36 @@
+package relay
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// RouteID 中继链路使用的路由id，中继节点与上游节点之间所有设备的报文都封装在该路由上
+const RouteID uint32 = 6000
+
+// 中继帧类型
+const (
+	frameOpen  byte = iota + 1 // 设备接入中继节点，载荷为设备地址 host:port
+	frameData                  // 设备报文，载荷为编码后的完整报文
+	frameClose                 // 设备断开
+)
+
+// frameHeadLen 帧头长度 op(1) + 设备连接id(8)
+const frameHeadLen = 9
+
+var errInvalidFrame = errors.New("invalid relay frame")
+
+func encodeFrame(op byte, id uint64, payload []byte) []byte {
+	buf := make([]byte, frameHeadLen+len(payload))
+	buf[0] = op
+	binary.BigEndian.PutUint64(buf[1:frameHeadLen], id)
+	copy(buf[frameHeadLen:], payload)
+	return buf
+}
+
+func decodeFrame(data []byte) (byte, uint64, []byte, error) {
+	if len(data) < frameHeadLen {
+		return 0, 0, nil, errInvalidFrame
+	}
+	return data[0], binary.BigEndian.Uint64(data[1:frameHeadLen]), data[frameHeadLen:], nil
+}
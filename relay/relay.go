@@ -0,0 +1,209 @@
+package relay
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+	"sync"
+)
+
+// Relay 跳板机模式下的中继，将接入的设备报文经单条上游链路转发给上游dusnet节点，
+// 并将上游下发的报文原样写回设备，中继节点本身不需要任何业务handler
+type Relay struct {
+	network string
+	address string
+	codec0  zcodec.Icodec
+	connMgr connect.IConnectionMgr         // 中继节点的设备连接管理器
+	dial    sync.Mutex                     // 串行建立上游链路，拨号及认证期间不持有lock
+	lock    sync.Mutex                     // 保护链路切换及写入，保证帧不交错
+	link    connect.IConnection            // 上游链路，懒加载，断开后重建
+	devices map[uint64]connect.IConnection // 设备连接id -> 中继该设备的上游链路
+	login   func(link connect.IConnection) error
+}
+
+var errLinkClosed = errors.New("relay upstream link closed")
+
+// New 返回中继，address为上游dusnet节点地址，connMgr为中继server的连接管理器
+func New(network string, address string, connMgr connect.IConnectionMgr) *Relay {
+	return &Relay{
+		network: network,
+		address: address,
+		codec0:  zcodec.Default(),
+		connMgr: connMgr,
+		devices: make(map[uint64]connect.IConnection),
+	}
+}
+
+// SetCodec 设置解析设备报文及封装中继帧使用的编解码器
+func (r *Relay) SetCodec(codec zcodec.Icodec) {
+	r.codec0 = codec
+}
+
+// SetLogin 设置上游链路建立后的认证，上游节点只接受已认证的中继链路，通常为：
+// func(link connect.IConnection) error { _, err := auth.Login(link, codec, device, key); return err }
+func (r *Relay) SetLogin(login func(link connect.IConnection) error) {
+	r.login = login
+}
+
+// Address 上游节点地址
+func (r *Relay) Address() string {
+	return r.address
+}
+
+// Serve 中继一个设备连接，直到设备断开或中继该设备的上游链路断开
+func (r *Relay) Serve(conn connect.IConnection) error {
+	if conn == nil {
+		return errors.New("relay connection is nil")
+	}
+	link, err := r.upstream()
+	if err != nil {
+		return err
+	}
+	id := conn.GetID()
+	r.lock.Lock()
+	r.devices[id] = link
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.devices, id)
+		r.lock.Unlock()
+	}()
+	remote := connect.RemoteAddrPortOf(conn).String()
+	if err := r.send(link, frameOpen, id, []byte(remote)); err != nil {
+		return err
+	}
+	for {
+		pkt, err := r.codec0.Decode(conn)
+		if err != nil {
+			// 链路已断开时上游已清理该设备，不再通知
+			_ = r.send(link, frameClose, id, nil)
+			return err
+		}
+		connect.Renew(conn)
+		buf, err := r.codec0.Encode(pkt)
+		if err != nil {
+			_ = r.send(link, frameClose, id, nil)
+			return err
+		}
+		if err := r.send(link, frameData, id, buf); err != nil {
+			return err
+		}
+	}
+}
+
+// upstream 返回当前上游链路，尚未建立时拨号并认证
+func (r *Relay) upstream() (connect.IConnection, error) {
+	r.dial.Lock()
+	defer r.dial.Unlock()
+	r.lock.Lock()
+	link := r.link
+	r.lock.Unlock()
+	if link != nil {
+		return link, nil
+	}
+	link, err := connect.Dial(r.network, r.address)
+	if err != nil {
+		logger.Error("relay dial upstream[%s] error,error:%+v", r.address, err)
+		return nil, err
+	}
+	if r.login != nil {
+		if err := r.login(link); err != nil {
+			logger.Error("relay login upstream[%s] error,error:%+v", r.address, err)
+			_ = link.Close()
+			return nil, err
+		}
+	}
+	r.lock.Lock()
+	r.link = link
+	r.lock.Unlock()
+	logger.Info("relay upstream link to %s established", r.address)
+	go r.downlink(link)
+	return link, nil
+}
+
+// send 经link写入中继帧，link已断开时返回errLinkClosed，不会重建链路
+func (r *Relay) send(link connect.IConnection, op byte, id uint64, payload []byte) error {
+	pkt := &packet.Packet{}
+	pkt.ID = RouteID
+	pkt.Type = zcodec.TYPE_SYNC
+	pkt.Data = encodeFrame(op, id, payload)
+	r.lock.Lock()
+	if r.link != link {
+		r.lock.Unlock()
+		return errLinkClosed
+	}
+	// 认证时建立了会话加密的链路按会话加密，加密计数器与写入顺序一致
+	buf, err := zcodec.EncodeFor(r.codec0, link, pkt)
+	if err != nil {
+		r.lock.Unlock()
+		return err
+	}
+	err = link.Write(buf)
+	r.lock.Unlock()
+	if err != nil {
+		logger.Error("relay write upstream[%s] error,error:%+v", r.address, err)
+		r.closeLink(link)
+		return err
+	}
+	return nil
+}
+
+// downlink 读取上游下发的帧并写回对应设备
+func (r *Relay) downlink(link connect.IConnection) {
+	for {
+		pkt, err := r.codec0.Decode(link)
+		if err != nil {
+			logger.Error("relay read upstream[%s] error,error:%+v", r.address, err)
+			r.closeLink(link)
+			return
+		}
+		if pkt.GetID() != RouteID {
+			logger.Warn("relay drop upstream msg with unexpected route %d", pkt.GetID())
+			continue
+		}
+		op, id, payload, err := decodeFrame(pkt.GetData())
+		if err != nil {
+			logger.Warn("relay drop upstream msg,error:%+v", err)
+			continue
+		}
+		conn := r.connMgr.GetConnByID(id)
+		if conn == nil {
+			continue
+		}
+		switch op {
+		case frameData:
+			if err := conn.Write(payload); err != nil {
+				logger.Warn("relay write device connection[id=%d] error,error:%+v", id, err)
+			}
+		case frameClose:
+			if err := r.connMgr.RemoveConnByID(id); err != nil {
+				logger.Warn("relay remove device connection[id=%d] error,error:%+v", id, err)
+			}
+		}
+	}
+}
+
+// closeLink 关闭上游链路及经其中继的设备连接，设备重连后重建链路
+func (r *Relay) closeLink(link connect.IConnection) {
+	r.lock.Lock()
+	if r.link != link {
+		r.lock.Unlock()
+		return
+	}
+	r.link = nil
+	var ids []uint64
+	for id, l := range r.devices {
+		if l == link {
+			ids = append(ids, id)
+		}
+	}
+	r.lock.Unlock()
+	_ = link.Close()
+	for _, id := range ids {
+		if err := r.connMgr.RemoveConnByID(id); err != nil {
+			logger.Warn("relay remove device connection[id=%d] error,error:%+v", id, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package relay
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// Upstream 上游节点侧的中继链路解复用，为每个经中继接入的设备创建虚拟连接，
+// 虚拟连接保留设备真实地址，读写经中继链路完成，可直接交给路由处理器使用
+type Upstream struct {
+	codec0 zcodec.Icodec
+	onOpen func(connect.IConnection) // 设备经中继接入时回调，通常在其中启动路由循环
+	lock   sync.Mutex
+	links  map[connect.IConnection]*upstreamLink
+}
+
+// upstreamLink 一条中继链路及其上的虚拟连接
+type upstreamLink struct {
+	conn  connect.IConnection
+	lock  sync.Mutex // 多个虚拟连接并发写同一链路时保证帧不交错
+	conns map[uint64]*virtualConn
+}
+
+// NewUpstream 返回上游解复用，onOpen在每个设备接入时调用，为空时拒绝设备接入直到通过OnOpen设置
+func NewUpstream(onOpen func(connect.IConnection)) *Upstream {
+	return &Upstream{
+		codec0: zcodec.Default(),
+		onOpen: onOpen,
+		links:  make(map[connect.IConnection]*upstreamLink),
+	}
+}
+
+// OnOpen 设置设备经中继接入时的回调
+func (u *Upstream) OnOpen(onOpen func(connect.IConnection)) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.onOpen = onOpen
+}
+
+// SetCodec 设置封装中继帧使用的编解码器
+func (u *Upstream) SetCodec(codec zcodec.Icodec) {
+	u.codec0 = codec
+}
+
+// Handle 处理中继节点经link发来的一帧
+func (u *Upstream) Handle(link connect.IConnection, data []byte) error {
+	op, id, payload, err := decodeFrame(data)
+	if err != nil {
+		return err
+	}
+	l := u.link(link)
+	switch op {
+	case frameOpen:
+		vc := newVirtualConn(id, string(payload), l, u)
+		u.lock.Lock()
+		onOpen := u.onOpen
+		u.lock.Unlock()
+		if onOpen == nil {
+			logger.Warn("relay device connection[id=%d,raddr:%s] rejected,upstream not served", id, string(payload))
+			return l.write(frameClose, id, nil, u.codec0)
+		}
+		l.lock.Lock()
+		l.conns[id] = vc
+		l.lock.Unlock()
+		logger.Info("relay device connection[id=%d,raddr:%s] opened", id, string(payload))
+		onOpen(vc)
+	case frameData:
+		l.lock.Lock()
+		vc, ok := l.conns[id]
+		l.lock.Unlock()
+		if !ok {
+			return nil
+		}
+		vc.feed(payload)
+	case frameClose:
+		l.lock.Lock()
+		vc, ok := l.conns[id]
+		delete(l.conns, id)
+		l.lock.Unlock()
+		if ok {
+			vc.shutdown()
+		}
+	default:
+		return errInvalidFrame
+	}
+	return nil
+}
+
+func (u *Upstream) link(conn connect.IConnection) *upstreamLink {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for c, l := range u.links {
+		// 顺带清理已断开的链路及其虚拟连接
+		if !c.Alive() {
+			delete(u.links, c)
+			l.shutdown()
+		}
+	}
+	l, ok := u.links[conn]
+	if !ok {
+		l = &upstreamLink{conn: conn, conns: make(map[uint64]*virtualConn)}
+		u.links[conn] = l
+	}
+	return l
+}
+
+func (l *upstreamLink) write(op byte, id uint64, payload []byte, codec zcodec.Icodec) error {
+	pkt := &packet.Packet{}
+	pkt.ID = RouteID
+	pkt.Type = zcodec.TYPE_SYNC
+	pkt.Data = encodeFrame(op, id, payload)
+	buf, err := zcodec.EncodeFor(codec, l.conn, pkt)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.conn.Write(buf)
+}
+
+func (l *upstreamLink) shutdown() {
+	l.lock.Lock()
+	conns := l.conns
+	l.conns = make(map[uint64]*virtualConn)
+	l.lock.Unlock()
+	for _, vc := range conns {
+		vc.shutdown()
+	}
+}
+
+// virtualConn 经中继接入的设备在上游节点上的虚拟连接
+type virtualConn struct {
+	connect.Activity
+	id       uint64         // 连接id，可由连接管理器重新分配
+	relayID  uint64         // 设备在中继节点上的连接id，用于封装中继帧
+	remote   netip.AddrPort // 设备连接中继节点时的地址
+	link     *upstreamLink
+	upstream *Upstream
+	lock     sync.Mutex
+	cond     *sync.Cond
+	buf      []byte // 已收到未读取的设备数据
+	alive    bool
+	closed   bool // 已关闭，关闭后读取返回EOF
+}
+
+func newVirtualConn(id uint64, remote string, link *upstreamLink, upstream *Upstream) *virtualConn {
+	// 中继节点以ip:port(IPv6带方括号)发送设备地址，无法解析时为零值
+	addr, _ := netip.ParseAddrPort(remote)
+	vc := &virtualConn{Activity: connect.NewActivity(), id: id, relayID: id, remote: addr, link: link, upstream: upstream, alive: true}
+	vc.cond = sync.NewCond(&vc.lock)
+	return vc
+}
+
+func (v *virtualConn) feed(data []byte) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.buf = append(v.buf, data...)
+	v.cond.Broadcast()
+}
+
+// shutdown 中继节点通知设备断开或链路断开，返回是否为首次关闭
+func (v *virtualConn) shutdown() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	first := !v.closed
+	v.closed = true
+	v.alive = false
+	v.cond.Broadcast()
+	return first
+}
+
+func (v *virtualConn) Read(bytes []byte) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	n := 0
+	for n < len(bytes) {
+		for len(v.buf) == 0 && !v.closed {
+			v.cond.Wait()
+		}
+		if len(v.buf) == 0 {
+			return io.EOF
+		}
+		c := copy(bytes[n:], v.buf)
+		v.buf = v.buf[c:]
+		n += c
+	}
+	return nil
+}
+
+func (v *virtualConn) Write(bytes []byte) error {
+	v.lock.Lock()
+	closed := v.closed
+	v.lock.Unlock()
+	if closed {
+		return errors.New("relay connection closed")
+	}
+	return v.link.write(frameData, v.relayID, bytes, v.upstream.codec0)
+}
+
+func (v *virtualConn) Close() error {
+	v.link.lock.Lock()
+	delete(v.link.conns, v.relayID)
+	v.link.lock.Unlock()
+	if !v.shutdown() {
+		return nil
+	}
+	// 通知中继节点断开设备
+	return v.link.write(frameClose, v.relayID, nil, v.upstream.codec0)
+}
+
+func (v *virtualConn) Alive() bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.alive
+}
+
+func (v *virtualConn) SetAlive(alive bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.alive = alive
+}
+
+func (v *virtualConn) GetID() uint64 {
+	return v.id
+}
+
+func (v *virtualConn) SetID(id uint64) {
+	v.id = id
+}
+
+func (v *virtualConn) GetLocalHost() string {
+	return v.link.conn.GetLocalHost()
+}
+
+func (v *virtualConn) GetLocalPort() int {
+	return v.link.conn.GetLocalPort()
+}
+
+func (v *virtualConn) GetRemoteHost() string {
+	return v.remote.Addr().String()
+}
+
+func (v *virtualConn) GetRemotePort() int {
+	return int(v.remote.Port())
+}
+
+func (v *virtualConn) LocalAddr() net.Addr {
+	return connect.LocalAddrOf(v.link.conn)
+}
+
+// RemoteAddr 设备连接中继节点时的地址
+func (v *virtualConn) RemoteAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(v.remote)
+}
+
+func (v *virtualConn) RemoteAddrPort() netip.AddrPort {
+	return v.remote
+}
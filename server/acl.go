@@ -0,0 +1,50 @@
+package server
+
+import (
+	"dusnet/acl"
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/handler"
+	"dusnet/logger"
+	"dusnet/packet"
+)
+
+// denyBody 访问被拒绝时回复的报文体
+var denyBody = []byte("access denied")
+
+// WithACL 路由前按a检查连接是否允许发送该报文，拒绝时回复同id、同类型的拒绝报文并丢弃该报文，连接保持；
+// 启用认证时在认证之后检查
+func WithACL(a *acl.ACL) Option {
+	return func(m *mServer) {
+		m.acl = a
+	}
+}
+
+func (m *mServer) initACL() {
+	if m.acl == nil {
+		return
+	}
+	m.acl.SetConnMgr(m.connMgr)
+	m.intercept(func(conn connect.IConnection, pkt packet.IPacket) error {
+		if m.acl.Allow(conn, pkt) {
+			return nil
+		}
+		m.deny(conn, pkt)
+		return handler.ErrSkip
+	})
+}
+
+func (m *mServer) deny(conn connect.IConnection, pkt packet.IPacket) {
+	ackPkt := &packet.Packet{}
+	ackPkt.ID = pkt.GetID()
+	ackPkt.Type = pkt.GetType()
+	ackPkt.Data = denyBody
+	buf, err := zcodec.EncodeFor(m.codec0, conn, ackPkt)
+	if err != nil {
+		logger.Error("encode deny msg error,error:%+v", err)
+		return
+	}
+	if err := conn.Write(buf); err != nil {
+		logger.Warn("write deny msg to connection[id=%d] error,error:%+v", conn.GetID(), err)
+	}
+}
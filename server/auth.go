@@ -0,0 +1,55 @@
+package server
+
+import (
+	"dusnet/auth"
+	"dusnet/cluster"
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"errors"
+)
+
+// WithAuth 连接须先经auth.RouteID完成握手认证，认证前其他路由的报文被拒绝且连接被释放，
+// 超过握手超时仍未认证的连接被驱逐；认证完成后连接绑定到设备，握手处理器需另行注册：
+// handler.RegisterChildHandler(auth.RouteID, handler.NewAuth8000Handler(guard))
+func WithAuth(guard *auth.Guard) Option {
+	return func(m *mServer) {
+		m.auth = guard
+	}
+}
+
+// initAuth 认证拦截器先于其他拦截器执行
+func (m *mServer) initAuth() {
+	if m.auth == nil {
+		if m.cipher != nil {
+			logger.Warn("server[%s] session cipher requires WithAuth,encryption disabled", m.name)
+		}
+		return
+	}
+	m.auth.SetConnMgr(m.connMgr)
+	if m.cluster != nil {
+		// 集群消息自行校验签名，校验通过的节点链路不受握手超时限制
+		m.auth.Allow(cluster.RouteID)
+		m.cluster.OnPeerLink(m.auth.Trust)
+	}
+	m.intercept(m.auth.Intercept)
+	if m.cipher == nil {
+		return
+	}
+	c := *m.cipher
+	zcodec.SetPlainRoutes(auth.RouteID)
+	m.auth.OnAuthenticated(func(conn connect.IConnection, state auth.State) error {
+		if len(state.Key) == 0 || len(state.Nonce) == 0 {
+			return errors.New("session cipher requires key and nonce from handshake")
+		}
+		return zcodec.Establish(conn, c, state.Key, state.Nonce, state.PeerNonce, true)
+	})
+}
+
+// WithSessionCipher 认证完成后以c建立会话加密，之后默认编解码器对报文体加解密，握手路由保持明文；
+// 会话密钥由设备预共享密钥及握手双方的随机数派生，需使用auth.NewHMAC等提供随机数的认证器，否则认证失败
+func WithSessionCipher(c zcodec.Cipher) Option {
+	return func(m *mServer) {
+		m.cipher = &c
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+)
+
+// Delivery 广播投递结果，启用写队列时Sent表示已入队
+type Delivery struct {
+	Total  int `json:"total"`  // 匹配的连接数
+	Sent   int `json:"sent"`   // 写入成功的连接数
+	Failed int `json:"failed"` // 写入失败的连接数，含超过高水位被丢弃的
+}
+
+// ErrNoGroups 连接管理器未实现connect.IGroupMgr
+var ErrNoGroups = errors.New("connection manager does not support groups")
+
+// Broadcast 向所有存活连接发送pkt，报文只编码一次
+func (m *mServer) Broadcast(pkt packet.IPacket) (Delivery, error) {
+	return m.Multicast(nil, pkt)
+}
+
+// Multicast 向filter返回true的存活连接发送pkt，filter为空时等同于Broadcast；
+// 报文只编码一次，各连接共享同一只读帧(已建立加密会话的连接除外)，配合WithWriteQueue使用时慢连接不会阻塞其它连接
+func (m *mServer) Multicast(filter func(conn connect.IConnection) bool, pkt packet.IPacket) (Delivery, error) {
+	var conns []connect.IConnection
+	for _, conn := range m.connMgr.All() {
+		if filter == nil || filter(conn) {
+			conns = append(conns, conn)
+		}
+	}
+	return m.fanout("multicast", conns, pkt)
+}
+
+// SendGroup 向分组group内的连接发送pkt，报文只编码一次
+func (m *mServer) SendGroup(group string, pkt packet.IPacket) (Delivery, error) {
+	mgr, ok := m.connMgr.(connect.IGroupMgr)
+	if !ok {
+		return Delivery{}, ErrNoGroups
+	}
+	return m.fanout("group["+group+"]", mgr.Members(group), pkt)
+}
+
+// fanout 将pkt编码一次后写入conns中的存活连接
+func (m *mServer) fanout(scope string, conns []connect.IConnection, pkt packet.IPacket) (Delivery, error) {
+	var d Delivery
+	frame, err := m.codec0.Encode(pkt)
+	if err != nil {
+		logger.Error("%s encode error,error:%+v", scope, err)
+		return d, err
+	}
+	for _, conn := range conns {
+		if !conn.Alive() {
+			continue
+		}
+		d.Total++
+		buf := frame
+		if zcodec.Encrypted(conn) {
+			// 加密会话的密钥及计数器各不相同，只能逐个编码
+			if buf, err = zcodec.EncodeFor(m.codec0, conn, pkt); err != nil {
+				d.Failed++
+				continue
+			}
+		}
+		if err := conn.Write(buf); err != nil {
+			logger.Warn("%s write to connection[id=%d] error,error:%+v", scope, conn.GetID(), err)
+			d.Failed++
+			continue
+		}
+		d.Sent++
+	}
+	logger.Info("server[%s] %s msg[id:%d,type:%d] total:%d,sent:%d,failed:%d",
+		m.name, scope, pkt.GetID(), pkt.GetType(), d.Total, d.Sent, d.Failed)
+	return d, nil
+}
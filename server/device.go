@@ -0,0 +1,47 @@
+package server
+
+import (
+	"dusnet/connect"
+)
+
+// WithDeviceConfig 设置设备绑定配置，连接认证后通过connMgr.BindDevice绑定设备，
+// 同一设备重复接入时按conf.Policy踢掉旧连接或拒绝新连接，conf.MaxSessions为同一设备允许的会话数
+func WithDeviceConfig(conf connect.DeviceConfig) Option {
+	return func(m *mServer) {
+		m.device = &conf
+	}
+}
+
+// initDevices 被踢下线的连接经release释放；集群模式下设备绑定同步到集群节点，任意节点均可按设备id下发
+func (m *mServer) initDevices() {
+	mgr, ok := m.connMgr.(connect.IDeviceMgr)
+	if !ok {
+		return
+	}
+	conf := connect.DeviceConfig{}
+	if m.device != nil {
+		conf = *m.device
+	}
+	if m.cluster != nil {
+		onBind, onUnbind := conf.OnBind, conf.OnUnbind
+		conf.OnBind = func(device string, conn connect.IConnection) {
+			m.cluster.Bind(device, conn)
+			if onBind != nil {
+				onBind(device, conn)
+			}
+		}
+		conf.OnUnbind = func(device string, conn connect.IConnection) {
+			m.cluster.Unbind(device, conn)
+			// 允许多个会话时，由仍在线的最新会话接替
+			if conns := mgr.GetConnByDevice(device); len(conns) > 0 {
+				m.cluster.Bind(device, conns[len(conns)-1])
+			}
+			if onUnbind != nil {
+				onUnbind(device, conn)
+			}
+		}
+	}
+	// 与其他释放途径一致，同时清理认证、限流、隧道及reactor等状态
+	conf.OnKick = m.release
+	mgr.SetDeviceConfig(conf)
+}
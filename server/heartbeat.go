@@ -0,0 +1,103 @@
+package server
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"sync"
+	"time"
+)
+
+// pingRouteID 探测报文使用ping路由，设备按ping应答即可续租
+const pingRouteID uint32 = 1000
+
+// heartbeat 空闲连接检测，每个连接在时间轮上挂一个检测定时器，到期时空闲超过timeout的连接被驱逐，
+// 未超过则按剩余时间重新挂载，因此收到报文时只需续租，无需操作定时器；
+// 开启探测时先向空闲连接发送TYPE_PING报文，probeWait内仍未收到任何报文再驱逐
+type heartbeat struct {
+	server    *mServer
+	wheel     *connect.TimingWheel
+	timeout   time.Duration
+	probeWait time.Duration // 为0时不探测
+	stop      chan struct{}
+	closeOnce sync.Once // server重复Stop时只关闭一次
+}
+
+func newHeartbeat(m *mServer, timeout time.Duration, probeWait time.Duration) *heartbeat {
+	return &heartbeat{
+		server:    m,
+		wheel:     connect.DefaultTimingWheel(),
+		timeout:   timeout,
+		probeWait: probeWait,
+		stop:      make(chan struct{}),
+	}
+}
+
+// watch 开始检测连接
+func (h *heartbeat) watch(conn connect.IConnection) {
+	if conn == nil {
+		return
+	}
+	active, ok := conn.(connect.IActiveConnection)
+	if !ok {
+		logger.Debug("connection[id=%d] does not record activity,idle check skipped", conn.GetID())
+		return
+	}
+	var probed time.Time // 发送探测的时间，仅在时间轮驱动协程中访问
+	var check func()
+	check = func() {
+		select {
+		case <-h.stop:
+			return
+		default:
+		}
+		if !conn.Alive() {
+			return
+		}
+		now := time.Now()
+		idle := now.Sub(active.GetUpdateTime())
+		if idle < h.timeout {
+			probed = time.Time{}
+			h.wheel.AfterFunc(h.timeout-idle, check)
+			return
+		}
+		if h.probeWait > 0 {
+			if probed.IsZero() || active.GetUpdateTime().After(probed) {
+				probed = now
+				// 写入可能阻塞，不阻塞时间轮
+				go h.probe(conn)
+				h.wheel.AfterFunc(h.probeWait, check)
+				return
+			} else if now.Sub(probed) < h.probeWait {
+				h.wheel.AfterFunc(h.probeWait-now.Sub(probed), check)
+				return
+			}
+		}
+		logger.Warn("connection[id=%d,raddr:%s:%d] idle for %s,evicted", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), idle)
+		// 驱逐涉及关闭连接等IO，不阻塞时间轮
+		go h.server.release(conn)
+	}
+	h.wheel.AfterFunc(h.timeout, check)
+}
+
+func (h *heartbeat) probe(conn connect.IConnection) {
+	pkt := &packet.Packet{}
+	pkt.ID = pingRouteID
+	pkt.Type = zcodec.TYPE_PING
+	pkt.Data = []byte("ping")
+	buf, err := zcodec.EncodeFor(h.server.codec0, conn, pkt)
+	if err != nil {
+		logger.Error("encode ping probe error,error:%+v", err)
+		return
+	}
+	if err := conn.Write(buf); err != nil {
+		logger.Warn("connection[id=%d] ping probe error,error:%+v", conn.GetID(), err)
+	}
+}
+
+func (h *heartbeat) close() {
+	h.closeOnce.Do(func() {
+		close(h.stop)
+	})
+}
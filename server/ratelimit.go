@@ -0,0 +1,68 @@
+package server
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/handler"
+	"dusnet/logger"
+	"dusnet/packet"
+	"dusnet/ratelimit"
+	"errors"
+	"time"
+)
+
+// limitedBody 报文被限流时回复的报文体
+var limitedBody = []byte("rate limited")
+
+// WithRateLimit 路由前按l限流，超过限制时按规则延迟、丢弃、回复错误报文或断开连接；启用认证时在认证之后检查，
+// 启用处理协程池、reactor或UDP时delay动作按drop处理
+func WithRateLimit(l *ratelimit.Limiter) Option {
+	return func(m *mServer) {
+		m.limiter = l
+	}
+}
+
+func (m *mServer) initRateLimit() {
+	if m.limiter == nil {
+		return
+	}
+	m.limiter.SetConnMgr(m.connMgr)
+	if m.workers > 0 || m.reactorLoops > 0 || m.datagram() {
+		// 处理协程由多个连接共享，等待令牌会阻塞同一协程上的其他连接
+		m.limiter.DisableDelay()
+	}
+	m.intercept(func(conn connect.IConnection, pkt packet.IPacket) error {
+		d := m.limiter.Check(conn, pkt)
+		if d.Allowed {
+			if d.Wait > 0 {
+				// 只在连接独占的读取协程中等待，阻塞该连接后续报文的处理，对设备形成反压
+				time.Sleep(d.Wait)
+			}
+			return nil
+		}
+		logger.Debug("connection[id=%d,raddr:%s:%d] msg[id:%d] rate limited,action:%s", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), pkt.GetID(), d.Action)
+		switch d.Action {
+		case ratelimit.ActionReply:
+			m.replyLimited(conn, pkt)
+		case ratelimit.ActionDisconnect:
+			logger.Warn("connection[id=%d,raddr:%s:%d] exceeds rate limit,disconnect", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort())
+			return errors.New("rate limit exceeded")
+		}
+		return handler.ErrSkip
+	})
+}
+
+func (m *mServer) replyLimited(conn connect.IConnection, pkt packet.IPacket) {
+	ackPkt := &packet.Packet{}
+	ackPkt.ID = pkt.GetID()
+	ackPkt.Type = pkt.GetType()
+	ackPkt.Data = limitedBody
+	buf, err := zcodec.EncodeFor(m.codec0, conn, ackPkt)
+	if err != nil {
+		logger.Error("encode rate limited msg error,error:%+v", err)
+		return
+	}
+	if err := conn.Write(buf); err != nil {
+		logger.Warn("write rate limited msg to connection[id=%d] error,error:%+v", conn.GetID(), err)
+	}
+}
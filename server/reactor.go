@@ -0,0 +1,17 @@
+package server
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"syscall"
+)
+
+// maxReactorFrame 事件驱动模式下未设置WithMaxFrame时单个报文的最大长度，超过时视为非法连接
+const maxReactorFrame = zcodec.DefaultMaxFrame
+
+// feedConn 可由reactor投递报文的连接，connect.New返回的连接均实现此接口
+type feedConn interface {
+	connect.IConnection
+	Feed([]byte)
+	SyscallConn() (syscall.RawConn, error)
+}
@@ -0,0 +1,238 @@
+//go:build linux
+
+package server
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"errors"
+	"sync"
+	"syscall"
+)
+
+const (
+	reactorEvents   = 256       // 单次epoll_wait最多返回的事件数
+	reactorWaitMs   = 100       // epoll_wait超时，用于检查停止信号
+	reactorReadSize = 64 * 1024 // 单次读取的缓冲区大小
+)
+
+// reactor 事件驱动模式，少量事件循环通过epoll等待可读套接字，非阻塞读取后按编解码器分帧，
+// 完整报文解码后交给处理协程池路由，避免每个连接常驻一个阻塞读取的协程
+type reactor struct {
+	server *mServer
+	framer zcodec.IFramer
+	loops  []*eventLoop
+	stop   chan struct{}
+	once   sync.Once // 启动失败及server重复Stop时只关闭一次
+	max    int       // 单个报文的最大长度
+	lock   sync.Mutex
+	fds    map[uint64]int // 连接id -> fd，连接关闭后无法再取得fd，注销时使用
+}
+
+// eventLoop 一个epoll实例及其上的连接
+type eventLoop struct {
+	reactor *reactor
+	epfd    int
+	lock    sync.Mutex
+	conns   map[int]*reactorConn // fd -> 连接
+	readBuf []byte
+}
+
+// reactorConn reactor中的连接及其未成帧的数据
+type reactorConn struct {
+	conn feedConn
+	raw  syscall.RawConn
+	buf  []byte
+}
+
+func newReactor(m *mServer, loops int) (*reactor, error) {
+	framer, ok := m.codec0.(zcodec.IFramer)
+	if !ok {
+		return nil, errors.New("codec does not implement zcodec.IFramer")
+	}
+	r := &reactor{
+		server: m,
+		framer: framer,
+		stop:   make(chan struct{}),
+		max:    maxReactorFrame,
+		fds:    make(map[uint64]int),
+	}
+	if m.maxFrame > 0 {
+		r.max = m.maxFrame
+	}
+	for i := 0; i < loops; i++ {
+		epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		l := &eventLoop{
+			reactor: r,
+			epfd:    epfd,
+			conns:   make(map[int]*reactorConn),
+			readBuf: make([]byte, reactorReadSize),
+		}
+		r.loops = append(r.loops, l)
+	}
+	for _, l := range r.loops {
+		go l.run()
+	}
+	return r, nil
+}
+
+// add 将连接加入reactor
+func (r *reactor) add(conn connect.IConnection) error {
+	fc, ok := conn.(feedConn)
+	if !ok {
+		return errors.New("connection does not support reactor mode")
+	}
+	raw, err := fc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	if err := raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return err
+	}
+	l := r.loops[fd%len(r.loops)]
+	rc := &reactorConn{conn: fc, raw: raw}
+	r.lock.Lock()
+	r.fds[conn.GetID()] = fd
+	r.lock.Unlock()
+	l.lock.Lock()
+	l.conns[fd] = rc
+	l.lock.Unlock()
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, event); err != nil {
+		r.remove(conn)
+		return err
+	}
+	return nil
+}
+
+// remove 连接释放时从所属事件循环注销，需在关闭连接前调用
+func (r *reactor) remove(conn connect.IConnection) {
+	r.lock.Lock()
+	fd, ok := r.fds[conn.GetID()]
+	delete(r.fds, conn.GetID())
+	r.lock.Unlock()
+	if !ok {
+		return
+	}
+	l := r.loops[fd%len(r.loops)]
+	l.lock.Lock()
+	rc := l.conns[fd]
+	l.lock.Unlock()
+	if rc != nil && rc.conn == conn {
+		l.unregister(fd, rc)
+	}
+}
+
+func (r *reactor) close() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+func (l *eventLoop) run() {
+	defer syscall.Close(l.epfd)
+	events := make([]syscall.EpollEvent, reactorEvents)
+	for {
+		select {
+		case <-l.reactor.stop:
+			return
+		default:
+		}
+		n, err := syscall.EpollWait(l.epfd, events, reactorWaitMs)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			logger.Error("epoll_wait error,error:%+v", err)
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			l.lock.Lock()
+			rc, ok := l.conns[fd]
+			l.lock.Unlock()
+			if ok {
+				l.read(fd, rc)
+			}
+		}
+	}
+}
+
+// read 非阻塞读取套接字中的全部数据，分帧后投递给协程池
+func (l *eventLoop) read(fd int, rc *reactorConn) {
+	for {
+		var n int
+		var readErr error
+		err := rc.raw.Read(func(f uintptr) bool {
+			n, readErr = syscall.Read(int(f), l.readBuf)
+			// 始终返回true，不等待可读，由epoll通知
+			return true
+		})
+		if err == nil {
+			err = readErr
+		}
+		if n > 0 {
+			rc.buf = append(rc.buf, l.readBuf[:n]...)
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil || n == 0 {
+			l.remove(fd, rc)
+			return
+		}
+		if n < len(l.readBuf) {
+			break
+		}
+	}
+	for {
+		total, complete := l.reactor.framer.FrameLen(rc.buf)
+		if total > l.reactor.max {
+			logger.Warn("connection[id=%d] frame length %d exceeds limit", rc.conn.GetID(), total)
+			l.remove(fd, rc)
+			return
+		}
+		if !complete {
+			break
+		}
+		frame := make([]byte, total)
+		copy(frame, rc.buf[:total])
+		rc.buf = rc.buf[total:]
+		// 同一连接只在所属事件循环中解码，投递与解码无需加锁
+		rc.conn.Feed(frame)
+		pkt, err := l.reactor.server.codec0.Decode(rc.conn)
+		if err != nil {
+			l.remove(fd, rc)
+			return
+		}
+		connect.Renew(rc.conn)
+		if err := l.reactor.server.pool.submit(rc.conn, pkt); err != nil {
+			return
+		}
+	}
+	if len(rc.buf) == 0 {
+		rc.buf = nil
+	}
+}
+
+// remove 读取出错或对端关闭时注销并释放连接
+func (l *eventLoop) remove(fd int, rc *reactorConn) {
+	l.unregister(fd, rc)
+	go l.reactor.server.release(rc.conn)
+}
+
+// unregister 从事件循环及epoll中移除fd，fd已被新连接复用时不处理
+func (l *eventLoop) unregister(fd int, rc *reactorConn) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.conns[fd] == rc {
+		delete(l.conns, fd)
+		_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package server
+
+import (
+	"dusnet/connect"
+	"errors"
+)
+
+// reactor 事件驱动模式仅支持linux，其他平台回退为每连接一个协程
+type reactor struct {
+}
+
+func newReactor(m *mServer, loops int) (*reactor, error) {
+	return nil, errors.New("reactor mode only supported on linux")
+}
+
+func (r *reactor) add(conn connect.IConnection) error {
+	return errors.New("reactor mode only supported on linux")
+}
+
+func (r *reactor) remove(conn connect.IConnection) {
+}
+
+func (r *reactor) close() {
+}
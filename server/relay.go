@@ -0,0 +1,40 @@
+package server
+
+import (
+	"dusnet/connect"
+	"dusnet/handler"
+	"dusnet/logger"
+)
+
+// WithRelayUpstream 作为跳板机的上游节点，h需另行通过handler.RegisterChildHandler注册到relay.RouteID；
+// 中继链路须经WithAuth认证(握手或TLS客户端证书)，可通过acl限制只有中继节点的分组可调用relay.RouteID；
+// 经中继接入的设备作为虚拟连接加入连接管理器，与直连设备一样经认证、访问控制、限流及空闲检测后路由
+func WithRelayUpstream(h *handler.Relay6000Handler) Option {
+	return func(m *mServer) {
+		m.upstream = h
+	}
+}
+
+// initRelayUpstream 未启用认证时无法认证中继链路，拒绝所有中继链路
+func (m *mServer) initRelayUpstream() {
+	if m.upstream == nil {
+		return
+	}
+	if m.auth == nil {
+		logger.Error("server[%s] relay upstream requires WithAuth,all relay links will be rejected", m.name)
+	}
+	m.upstream.Serve(m.auth, m.serveRelayed)
+}
+
+// serveRelayed 经中继接入的设备加入连接管理器并路由
+func (m *mServer) serveRelayed(conn connect.IConnection) {
+	conn.SetID(m.connMgr.GenConnID())
+	m.connMgr.AddConn(conn)
+	if m.heartbeat != nil {
+		m.heartbeat.watch(conn)
+	}
+	if m.auth != nil {
+		m.auth.Watch(conn, m.release)
+	}
+	go m.serve(conn)
+}
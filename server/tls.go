@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"dusnet/connect"
+	"dusnet/logger"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultTLSReloadInterval   = time.Minute
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// TLSConfig TLS监听配置，对应config.yml中的tlsconfig
+type TLSConfig struct {
+	// CertFile 服务端证书文件(PEM)
+	CertFile string `json:"certfile" yaml:"certfile"`
+	// KeyFile 服务端私钥文件(PEM)
+	KeyFile string `json:"keyfile" yaml:"keyfile"`
+	// ClientCAFile 校验客户端证书的CA文件(PEM)，不为空时启用双向TLS
+	ClientCAFile string `json:"clientcafile" yaml:"clientcafile"`
+	// RequireClientCert 是否必须提供客户端证书，为false时未提供证书的连接仍需经认证握手
+	RequireClientCert bool `json:"requireclientcert" yaml:"requireclientcert"`
+	// ReloadInterval 检查证书文件变化的间隔，证书更新后新连接使用新证书，默认1分钟
+	ReloadInterval time.Duration `json:"reloadinterval" yaml:"reloadinterval"`
+	// HandshakeTimeout TLS握手超时时间，默认10秒
+	HandshakeTimeout time.Duration `json:"handshaketimeout" yaml:"handshaketimeout"`
+}
+
+// LoadTLSConfig 从config/config.yml的tlsconfig读取TLS配置
+func LoadTLSConfig() (TLSConfig, error) {
+	conf := TLSConfig{}
+	vp := viper.New()
+	vp.AddConfigPath("config")
+	vp.SetConfigName("config.yml")
+	vp.SetConfigType("yml")
+	if err := vp.ReadInConfig(); err != nil {
+		return conf, err
+	}
+	if !vp.IsSet("tlsconfig") {
+		return conf, errors.New("tlsconfig not found in config.yml")
+	}
+	err := vp.UnmarshalKey("tlsconfig", &conf)
+	return conf, err
+}
+
+// WithTLS 以TLS监听，conf.ClientCAFile不为空时校验客户端证书，并以identity(证书)作为设备id绑定连接，
+// identity为空时使用证书的Subject.CommonName；启用认证时证书认证的连接无需再经认证握手
+// TLS连接不支持reactor模式
+func WithTLS(conf TLSConfig, identity func(cert *x509.Certificate) string) Option {
+	return func(m *mServer) {
+		if identity == nil {
+			identity = func(cert *x509.Certificate) string {
+				return cert.Subject.CommonName
+			}
+		}
+		m.tlsConf = &conf
+		m.identity = identity
+	}
+}
+
+// certReloader 定期检查证书文件，变化时重新加载，握手时返回当前证书及客户端CA
+type certReloader struct {
+	conf    TLSConfig
+	lock    sync.RWMutex
+	config  *tls.Config
+	modTime time.Time
+	stop    chan struct{}
+	once    sync.Once // server重复Stop时只关闭一次
+}
+
+func newCertReloader(conf TLSConfig) (*certReloader, error) {
+	if conf.ReloadInterval <= 0 {
+		conf.ReloadInterval = defaultTLSReloadInterval
+	}
+	r := &certReloader{conf: conf, stop: make(chan struct{})}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+// tlsConfig 监听器使用的配置，每次握手取当前加载的配置
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+			return r.config, nil
+		},
+	}
+}
+
+func (r *certReloader) load() error {
+	modTime := r.lastModified()
+	cert, err := tls.LoadX509KeyPair(r.conf.CertFile, r.conf.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if r.conf.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.conf.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New(fmt.Sprintf("no certificate found in %s", r.conf.ClientCAFile))
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.conf.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	r.lock.Lock()
+	r.config = config
+	r.modTime = modTime
+	r.lock.Unlock()
+	return nil
+}
+
+// lastModified 证书、私钥及CA文件中最近的修改时间
+func (r *certReloader) lastModified() time.Time {
+	var latest time.Time
+	for _, file := range []string{r.conf.CertFile, r.conf.KeyFile, r.conf.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *certReloader) run() {
+	ticker := time.NewTicker(r.conf.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.lock.RLock()
+			changed := r.lastModified().After(r.modTime)
+			r.lock.RUnlock()
+			if !changed {
+				continue
+			}
+			// 证书与私钥可能未同时写完，加载失败时保留旧证书，下次检查时重试
+			if err := r.load(); err != nil {
+				logger.Error("reload tls certificate error,error:%+v", err)
+				continue
+			}
+			logger.Info("tls certificate reloaded from %s", r.conf.CertFile)
+		}
+	}
+}
+
+func (r *certReloader) close() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+// handshake 完成TLS握手，客户端证书映射为设备id并绑定到连接
+func (m *mServer) handshake(conn connect.IConnection) error {
+	tc, ok := conn.(connect.ITLSConnection)
+	if !ok {
+		return nil
+	}
+	timeout := m.tlsConf.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultTLSHandshakeTimeout
+	}
+	if err := tc.Handshake(timeout); err != nil {
+		return err
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	device := m.identity(certs[0])
+	if device == "" {
+		return nil
+	}
+	if m.auth != nil {
+		return m.auth.Grant(conn, device)
+	}
+	if mgr, ok := m.connMgr.(connect.IDeviceMgr); ok {
+		return mgr.BindDevice(device, conn.GetID())
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"dusnet/connect"
+	"dusnet/logger"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultUDPIdleTimeout 未配置WithIdleTimeout时UDP对端的空闲过期时间
+const defaultUDPIdleTimeout = 2 * time.Minute
+
+// datagram network为udp、udp4或udp6时以UDP模式启动，每个对端地址作为一个虚拟连接，
+// 每个数据报可包含一个或多个完整报文，handler的回复经同一套接字发往对端；
+// 源地址可伪造，启用WithAuth时对端认证后的首个报文起才不限制回复，此前及未启用认证时回复的字节数不超过收到的字节数，
+// 准入控制按源地址限制对端，PROXY协议、TLS、relay及mux仅对TCP生效
+func (m *mServer) datagram() bool {
+	return strings.HasPrefix(m.network, "udp")
+}
+
+// listenUDP 按配置监听UDP
+func (m *mServer) listenUDP() (*connect.UDPListener, error) {
+	addr, err := net.ResolveUDPAddr(m.network, net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+	if err != nil {
+		logger.Error("net.ResolveUDPAddr error,error:%+v", err)
+		return nil, err
+	}
+	network := m.network
+	if m.dualStack && (addr.IP == nil || addr.IP.IsUnspecified()) {
+		// 通配地址不指定ip族时同时监听IPv4及IPv6
+		network = "udp"
+		addr.IP = nil
+	}
+	l, err := connect.ListenUDP(network, addr, m.connMgr)
+	if err != nil {
+		logger.Error("net.ListenUDP error,error:%+v", err)
+		return nil, err
+	}
+	if m.admission != nil {
+		l.SetAdmit(m.admission.Admit)
+	}
+	return l, nil
+}
+
+// serveUDP 循环读取数据报，解码出的报文提交给处理协程池；解码失败只丢弃该数据报，对端保留
+func (m *mServer) serveUDP(l *connect.UDPListener) {
+	for {
+		conn, fresh, err := l.ReadFrom()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Error("udp ReadFrom error,error:%+v", err)
+			continue
+		}
+		if fresh {
+			logger.Info("udp peer connection[id=%d,raddr:%s] opened", conn.GetID(), conn.RemoteAddrPort())
+			if m.heartbeat != nil {
+				m.heartbeat.watch(conn)
+			}
+			if m.auth != nil {
+				m.auth.Watch(conn, m.release)
+			}
+		}
+		if m.auth != nil && m.auth.Authenticated(conn) {
+			// 能完成握手说明对端确实持有该地址
+			conn.Verify()
+		}
+		for conn.Buffered() > 0 {
+			pkt, err := m.codec0.Decode(conn)
+			if err != nil {
+				logger.Warn("udp datagram from connection[id=%d,raddr:%s] dropped,error:%+v", conn.GetID(), conn.RemoteAddrPort(), err)
+				break
+			}
+			conn.Renew()
+			if err := m.pool.submit(conn, pkt); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/handler"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+	"sync"
+)
+
+// QueueFullPolicy 处理协程池队列已满时的策略
+type QueueFullPolicy int
+
+const (
+	PolicyBlock  QueueFullPolicy = iota // 阻塞读取直到队列有空位，读取侧随之暂停，形成背压
+	PolicyDrop                          // 丢弃报文
+	PolicyReject                        // 丢弃报文并向设备回复错误报文
+)
+
+func (p QueueFullPolicy) String() string {
+	switch p {
+	case PolicyDrop:
+		return "drop"
+	case PolicyReject:
+		return "reject"
+	default:
+		return "block"
+	}
+}
+
+const defaultQueueSize = 1024
+
+// rejectBody 队列已满拒绝报文时回复的包体，回复报文的id、类型与被拒绝的报文一致
+var rejectBody = []byte("server busy")
+
+var errQueueFull = errors.New("worker pool queue full")
+
+// poolTask 一个待路由的报文
+type poolTask struct {
+	conn connect.IConnection
+	pkt  packet.IPacket
+}
+
+// workerPool 有界处理协程池，按连接id将报文分配到固定协程的队列，同一连接的报文按序处理，
+// 不同连接的报文并发处理，单个处理慢的handler只影响与其共享队列的连接
+type workerPool struct {
+	server *mServer
+	router handler.IConnRouter
+	queues []chan poolTask
+	policy QueueFullPolicy
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once // server重复Stop时只关闭一次
+}
+
+func newWorkerPool(m *mServer, workers int, queueSize int, policy QueueFullPolicy) *workerPool {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	w := &workerPool{
+		server: m,
+		router: m.routeHandler.(handler.IConnRouter),
+		queues: make([]chan poolTask, workers),
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+	for i := range w.queues {
+		w.queues[i] = make(chan poolTask, queueSize)
+		w.wg.Add(1)
+		go w.run(w.queues[i])
+	}
+	return w
+}
+
+// submit 提交报文，队列已满时按策略处理
+func (w *workerPool) submit(conn connect.IConnection, pkt packet.IPacket) error {
+	task := poolTask{conn: conn, pkt: pkt}
+	queue := w.queues[conn.GetID()%uint64(len(w.queues))]
+	if w.policy == PolicyBlock {
+		select {
+		case queue <- task:
+			return nil
+		case <-w.stop:
+			return errQueueFull
+		}
+	}
+	select {
+	case queue <- task:
+		return nil
+	default:
+	}
+	logger.Warn("worker pool queue full,msg[id:%d,type:%d] from connection[id=%d] %s",
+		pkt.GetID(), pkt.GetType(), conn.GetID(), w.policy)
+	if w.policy == PolicyReject {
+		w.reject(conn, pkt)
+	}
+	return nil
+}
+
+func (w *workerPool) reject(conn connect.IConnection, pkt packet.IPacket) {
+	ackPkt := &packet.Packet{}
+	ackPkt.ID = pkt.GetID()
+	ackPkt.Type = pkt.GetType()
+	ackPkt.Data = rejectBody
+	buf, err := zcodec.EncodeFor(w.server.codec0, conn, ackPkt)
+	if err != nil {
+		logger.Error("encode reject msg error,error:%+v", err)
+		return
+	}
+	if err := conn.Write(buf); err != nil {
+		logger.Warn("write reject msg to connection[id=%d] error,error:%+v", conn.GetID(), err)
+	}
+}
+
+func (w *workerPool) run(queue chan poolTask) {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case task := <-queue:
+			if !task.conn.Alive() {
+				continue
+			}
+			if err := w.router.HandlePacket(task.conn, task.pkt); err != nil {
+				logger.Error("routeHandler.HandlePacket() error,error:%+v", err)
+				w.server.release(task.conn)
+			}
+		}
+	}
+}
+
+func (w *workerPool) close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	w.wg.Wait()
+}
@@ -1,21 +1,206 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"dusnet/acl"
+	"dusnet/admission"
+	"dusnet/auth"
+	"dusnet/cluster"
 	zcodec "dusnet/codec"
 	"dusnet/connect"
 	"dusnet/handler"
 	"dusnet/logger"
-	"fmt"
+	"dusnet/mux"
+	"dusnet/packet"
+	"dusnet/proxyproto"
+	"dusnet/ratelimit"
+	"dusnet/registry"
+	"dusnet/relay"
+	"dusnet/tunnel"
+	"errors"
 	"net"
 	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
 )
 
-type Option func()
+// Option server可选配置，可在构建时或启动时传入
+type Option func(*mServer)
+
+// WithCluster 以集群模式启动，连接断开时解绑其上的设备，停止时节点下线
+// 集群处理器需另行通过handler.RegisterChildHandler注册到cluster.RouteID，节点间密钥需通过node.SetSecret设置，
+// 启用WithAuth时节点链路以消息签名认证，无需握手
+// 节点通过cluster.NewHA启用主备模式时，standby会将设备重定向到primary
+func WithCluster(node *cluster.Node) Option {
+	return func(m *mServer) {
+		m.cluster = node
+	}
+}
+
+// WithRelay 以跳板机模式启动，接入的设备报文经r转发给上游节点处理，本节点的子处理器不生效
+// 上游节点需通过handler.RegisterChildHandler将handler.Relay6000Handler注册到relay.RouteID并启用WithRelayUpstream，
+// 中继链路需通过r.SetLogin认证
+func WithRelay(r *relay.Relay) Option {
+	return func(m *mServer) {
+		m.relay = r
+	}
+}
+
+// WithMux 接入的每条连接均作为多路复用会话，对端通过mux.Client打开的每个流都是一个独立的逻辑连接
+func WithMux() Option {
+	return func(m *mServer) {
+		m.mux = true
+	}
+}
+
+// WithTunnel 启用反向隧道，连接断开时关闭其上的隧道
+// 隧道处理器需另行通过handler.RegisterChildHandler将handler.Tunnel7000Handler注册到tunnel.RouteID
+func WithTunnel(manager *tunnel.Manager) Option {
+	return func(m *mServer) {
+		m.tunnel = manager
+	}
+}
+
+// WithIdleTimeout 连接超过timeout未收到任何报文时驱逐
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(m *mServer) {
+		m.idleTimeout = timeout
+	}
+}
+
+// WithPingProbe 空闲超时的连接先下发TYPE_PING探测，wait内仍未收到任何报文再驱逐，需配合WithIdleTimeout使用
+func WithPingProbe(wait time.Duration) Option {
+	return func(m *mServer) {
+		m.probeWait = wait
+	}
+}
+
+// WithCodec 设置与自定义路由处理器一致的编解码器，默认为zcodec.Default()
+func WithCodec(codec zcodec.Icodec) Option {
+	return func(m *mServer) {
+		m.codec0 = codec
+		m.limitFrame()
+	}
+}
+
+// WithReadTimeouts 以zcodec.NewWithTimeouts(header, body)替换服务端及路由处理器的编解码器，
+// 报文头首字节到达后header内未收全报文头、或报文头收全后body内未收全报文体的连接被断开，
+// 超时次数见zcodec.Timeouts()；事件驱动模式下不占用协程，无需此保护。
+// 首字节到达前视为空闲，接入后从不发送数据的连接只能由WithIdleTimeout驱逐，两者需配合使用
+func WithReadTimeouts(header time.Duration, body time.Duration) Option {
+	return func(m *mServer) {
+		m.codec0 = zcodec.NewWithTimeouts(header, body)
+		m.limitFrame()
+		m.routeHandler.SetCodec(m.codec0)
+		m.readTimeouts = true
+	}
+}
+
+// WithMaxFrame 限制单个报文含报文头的最大长度，默认为zcodec.DefaultMaxFrame，超过时连接被断开；
+// 同时作用于服务端及路由处理器的编解码器，编解码器需实现zcodec.IFrameLimiter
+func WithMaxFrame(max int) Option {
+	return func(m *mServer) {
+		m.maxFrame = max
+		m.limitFrame()
+		m.routeHandler.SetCodec(m.codec0)
+	}
+}
+
+// limitFrame 按WithMaxFrame限制编解码器的报文长度
+func (m *mServer) limitFrame() {
+	if m.maxFrame <= 0 {
+		return
+	}
+	if l, ok := m.codec0.(zcodec.IFrameLimiter); ok {
+		l.SetMaxFrame(m.maxFrame)
+	} else {
+		logger.Warn("server[%s] codec does not implement zcodec.IFrameLimiter,max frame ignored", m.name)
+	}
+}
+
+// WithReactor 以事件驱动模式启动(仅linux)，loops个事件循环通过epoll读取连接，完整报文交给workers个协程路由，
+// 处理器接口不变，同一连接的报文仍按序处理；编解码器需实现zcodec.IFramer，不满足条件时回退为每连接一个协程，
+// 无法注册到事件循环的单个连接(如已预读数据的PROXY协议连接)同样由独立协程读取
+// 同时使用WithWorkerPool时以WithWorkerPool的协程数为准，事件循环不能阻塞，队列已满时PolicyBlock按PolicyDrop处理
+func WithReactor(loops int, workers int) Option {
+	return func(m *mServer) {
+		if loops <= 0 {
+			loops = runtime.NumCPU()
+		}
+		m.reactorLoops = loops
+		if m.workers == 0 {
+			m.workers = workers
+		}
+	}
+}
+
+// WithWorkerPool 读取与处理分离，读取到的报文交给workers个处理协程，每个协程的队列长度为queueSize，
+// 同一连接的报文总是由同一协程按序处理，队列已满时按policy阻塞、丢弃或回复错误报文
+func WithWorkerPool(workers int, queueSize int, policy QueueFullPolicy) Option {
+	return func(m *mServer) {
+		m.workers = workers
+		m.queueSize = queueSize
+		m.policy = policy
+	}
+}
+
+// WithWriteQueue 为每个连接启用异步写队列，写入方只入队不阻塞，由连接的写协程按序写出，
+// 待写入数据超过conf.HighWater时按conf.Policy丢弃或断开慢连接
+func WithWriteQueue(conf connect.WriteQueueConfig) Option {
+	return func(m *mServer) {
+		m.writeQueue = &conf
+	}
+}
+
+// WithAdmission 接受连接时按c检查名单及连接数上限，未通过的连接直接关闭，规则可通过c.Reload在运行时更新
+func WithAdmission(c *admission.Controller) Option {
+	return func(m *mServer) {
+		m.admission = c
+	}
+}
+
+// WithProxyProtocol 解析来自trusted(负载均衡的ip或网段)的连接的PROXY协议v1/v2报文头，
+// 连接的远程地址为报文头中的真实客户端地址，准入控制及限流均按真实地址生效
+func WithProxyProtocol(trusted ...string) Option {
+	return func(m *mServer) {
+		m.proxyTrusted = append([]string{}, trusted...)
+	}
+}
+
+// WithDualStack host为通配地址(0.0.0.0、::或空)时同时监听IPv4及IPv6，否则按network及host监听
+func WithDualStack() Option {
+	return func(m *mServer) {
+		m.dualStack = true
+	}
+}
+
+// WithRegistry 启动时将server注册到reg中，停止时注销，service为注册的服务名称
+func WithRegistry(reg registry.Registry, service string) Option {
+	return func(m *mServer) {
+		m.registry = reg
+		m.service = service
+	}
+}
+
+// WithAdvertise 注册到注册中心的对外地址，监听通配地址时客户端无法直接连接监听地址，
+// 未设置时通配地址取本机第一个非回环地址
+func WithAdvertise(host string) Option {
+	return func(m *mServer) {
+		m.advertise = host
+	}
+}
 
 // IServer server端抽象
 type IServer interface {
-	Start(opts ...Option) error // 启动
-	Stop() error                // 停止
+	Start(opts ...Option) error                                                                 // 启动
+	Stop() error                                                                                // 停止
+	Broadcast(pkt packet.IPacket) (Delivery, error)                                             // 向所有连接发送报文
+	Multicast(filter func(conn connect.IConnection) bool, pkt packet.IPacket) (Delivery, error) // 向filter返回true的连接发送报文
+	SendGroup(group string, pkt packet.IPacket) (Delivery, error)                               // 向分组内的连接发送报文
+	ConnMgr() connect.IConnectionMgr                                                            // 获取连接管理器，用于连接分组等
 }
 
 type mServer struct {
@@ -25,81 +210,470 @@ type mServer struct {
 	port         int                    // 端口
 	routeHandler handler.IRouteHandler  // 基础处理器
 	connMgr      connect.IConnectionMgr // 连接管理器
+	registry     registry.Registry      // 注册中心，为空时不注册
+	service      string                 // 注册的服务名称
+	advertise    string                 // 注册的对外host，为空时按监听地址推断
+	cluster      *cluster.Node          // 集群节点，为空时为单机模式
+	relay        *relay.Relay           // 跳板机中继，不为空时不路由报文，全部转发给上游节点
+	mux          bool                   // 连接是否为多路复用会话
+	tunnel       *tunnel.Manager        // 反向隧道，连接断开时关闭其上的隧道
+	idleTimeout  time.Duration          // 连接空闲超时，为0时不检测
+	probeWait    time.Duration          // 空闲超时后PING探测的等待时间，为0时不探测直接驱逐
+	heartbeat    *heartbeat
+	codec0       zcodec.Icodec // 与路由处理器一致的编解码器，用于reactor分帧及心跳探测
+	maxFrame     int           // 单个报文的最大长度，为0时使用编解码器的默认限制
+	readTimeouts bool          // 是否设置了报文头及报文体读取超时
+	reactorLoops int           // 事件循环数量，为0时每个连接一个协程阻塞读取
+	reactor      *reactor
+	workers      int             // 处理协程数量，为0时在读取协程中直接处理
+	queueSize    int             // 每个处理协程的队列长度
+	policy       QueueFullPolicy // 队列已满时的策略
+	pool         *workerPool
+	writeQueue   *connect.WriteQueueConfig // 连接异步写队列配置，为空时同步写入
+	upstream     *handler.Relay6000Handler // 作为跳板机的上游节点时的中继链路处理器
+	device       *connect.DeviceConfig     // 设备绑定配置
+	auth         *auth.Guard               // 认证守卫，为空时不认证
+	tlsConf      *TLSConfig                // TLS配置，为空时明文监听
+	identity     func(cert *x509.Certificate) string
+	certs        *certReloader
+	cipher       *zcodec.Cipher        // 会话加密算法，为空时不加密
+	acl          *acl.ACL              // 路由访问控制，为空时不检查
+	limiter      *ratelimit.Limiter    // 限流器，为空时不限流
+	admission    *admission.Controller // 连接准入控制，为空时接受所有连接
+	proxyTrusted []string              // 可信的PROXY协议上游，为空时不解析PROXY协议
+	dualStack    bool                  // 通配地址时同时监听IPv4及IPv6
+	udpListener  *connect.UDPListener  // network为udp、udp4或udp6时的UDP监听
 }
 
 // Default 返回默认的server实现
-func Default(name string, network string, host string, port int) IServer {
-	return mServer{
+func Default(name string, network string, host string, port int, opts ...Option) IServer {
+	m := &mServer{
 		name:         name,
 		network:      network,
 		host:         host,
 		port:         port,
 		routeHandler: handler.RouteBuilder().Codec(zcodec.Default()).Build(), //使用默认内置路由handler
 		connMgr:      connect.DefaultConnMgr(),
+		codec0:       zcodec.Default(),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 // New 自定义server实现，可以启用自己的handler及编解码器、连接管理器
-func New(name string, network string, host string, port int, routeHandler handler.IRouteHandler, connMgr connect.IConnectionMgr) IServer {
-	return mServer{
+func New(name string, network string, host string, port int, routeHandler handler.IRouteHandler, connMgr connect.IConnectionMgr, opts ...Option) IServer {
+	m := &mServer{
 		name:         name,
 		network:      network,
 		host:         host,
 		port:         port,
 		routeHandler: routeHandler, //使用自定义路由handler及编解码器
 		connMgr:      connMgr,      //使用自定义连接管理器
+		codec0:       zcodec.Default(),
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	return m
 }
 
-func (m mServer) Start(opts ...Option) error {
+func (m *mServer) Start(opts ...Option) error {
+	for _, opt := range opts {
+		opt(m)
+	}
 	printServerEnv(m)
-	addr, err := net.ResolveTCPAddr(m.network, fmt.Sprintf("%s:%d", m.host, m.port))
-	if err != nil {
-		logger.Error("net.ResolveTCPAddr error,error:%+v", err)
+	if err := m.checkExtensions(); err != nil {
+		logger.Error("server[%s] start error,error:%+v", m.name, err)
 		return err
 	}
-	listener, err := net.ListenTCP(m.network, addr)
+	var listener net.Listener
+	var err error
+	if m.datagram() {
+		m.udpListener, err = m.listenUDP()
+	} else {
+		listener, err = m.listenTCP()
+	}
 	if err != nil {
-		logger.Error("net.ListenTCP error,error:%+v", err)
 		return err
 	}
+	m.initDevices()
+	m.initAuth()
+	m.initRateLimit()
+	m.initACL()
+	if m.udpListener != nil && m.idleTimeout <= 0 {
+		// UDP没有断开事件，对端只能按空闲过期
+		m.idleTimeout = defaultUDPIdleTimeout
+	}
+	if m.idleTimeout > 0 {
+		m.heartbeat = newHeartbeat(m, m.idleTimeout, m.probeWait)
+	} else if m.readTimeouts {
+		logger.Warn("server[%s] read timeouts start at the first byte,connections that never send are kept without WithIdleTimeout", m.name)
+	}
+	m.initRelayUpstream()
+	if m.reactorLoops > 0 {
+		if m.relay != nil || m.mux || m.tlsConf != nil || m.udpListener != nil {
+			logger.Warn("server[%s] reactor mode not supported with relay, mux, tls or udp,fallback to goroutine per connection", m.name)
+		} else if r, err := newReactor(m, m.reactorLoops); err != nil {
+			logger.Warn("server[%s] start reactor error,fallback to goroutine per connection,error:%+v", m.name, err)
+		} else {
+			m.reactor = r
+			if m.workers <= 0 {
+				// 事件循环中不执行handler，必须使用处理协程池
+				m.workers = runtime.NumCPU() * 4
+			}
+			if m.policy == PolicyBlock {
+				// 阻塞提交会停住整个事件循环上的所有连接
+				logger.Warn("server[%s] reactor mode does not support queue policy block,use drop", m.name)
+				m.policy = PolicyDrop
+			}
+		}
+	}
+	if m.udpListener != nil && m.workers <= 0 {
+		// 所有对端共用一个读取协程，handler必须在处理协程池中执行
+		m.workers = runtime.NumCPU() * 4
+	}
+	if m.workers > 0 {
+		m.pool = newWorkerPool(m, m.workers, m.queueSize, m.policy)
+	}
 	logger.Info("server[%s] started on %s:%d", m.name, m.host, m.port)
 	logger.Debug("")
-	for _, opt := range opts {
-		opt()
-	}
-	go func() {
-		for {
-			conn := connect.New(listener, m.connMgr)
-			go func() {
-				for {
-					m.routeHandler.BindConn(conn)
-					err := m.routeHandler.HandleMsg0()
-					if err != nil {
-						logger.Error("routeHandler.HandleMsg0() error,error:%+v", err)
-						if conn != nil {
-							err := m.connMgr.RemoveConnByID(conn.GetID())
-							if err != nil {
-								logger.Error("remove conn error,error:%+v", err)
-							}
-							logger.Warn("One connection[id=%d,laddr:%s:%d,raddr:%s:%d] released",
-								conn.GetID(), conn.GetLocalHost(), conn.GetLocalPort(), conn.GetRemoteHost(), conn.GetRemotePort())
-						}
-						return
-					}
-				}
-			}()
-		}
-	}()
+	if m.udpListener != nil {
+		go m.serveUDP(m.udpListener)
+	} else {
+		go m.accept(listener)
+	}
+	if m.cluster != nil && m.cluster.HA() != nil {
+		m.startHA(m.cluster.HA())
+	}
+	if m.registry != nil {
+		if err := m.registry.Register(m.instance()); err != nil {
+			logger.Error("server[%s] register to registry error,error:%+v", m.name, err)
+			return err
+		}
+		logger.Info("server[%s] registered as service[%s]", m.name, m.service)
+		if m.cluster != nil {
+			// 通过注册中心发现集群内其他节点
+			if err := m.cluster.Join(m.registry, m.service); err != nil {
+				logger.Error("server[%s] join cluster error,error:%+v", m.name, err)
+				return err
+			}
+		}
+	}
 	return err
 }
 
+// checkExtensions 自定义路由处理器及连接管理器未实现所需的可选接口时，拒绝启动依赖它们的功能；
+// 处理协程池仅作为优化时降级为每个连接一个协程直接处理
+func (m *mServer) checkExtensions() error {
+	if _, ok := m.routeHandler.(handler.IInterceptable); !ok {
+		if m.auth != nil || m.acl != nil || m.limiter != nil || m.cluster != nil && m.cluster.HA() != nil {
+			return errors.New("auth, acl, rate limit and ha require route handler implementing handler.IInterceptable")
+		}
+	}
+	if _, ok := m.connMgr.(connect.IDeviceMgr); !ok && (m.device != nil || m.cluster != nil) {
+		return errors.New("device config and cluster require connection manager implementing connect.IDeviceMgr")
+	}
+	if _, ok := m.routeHandler.(handler.IConnRouter); !ok {
+		if m.datagram() {
+			return errors.New("udp requires route handler implementing handler.IConnRouter")
+		}
+		if m.workers > 0 || m.reactorLoops > 0 {
+			logger.Warn("server[%s] worker pool and reactor require route handler implementing handler.IConnRouter,fallback to goroutine per connection", m.name)
+			m.workers, m.reactorLoops = 0, 0
+		}
+	}
+	return nil
+}
+
+// intercept 添加路由拦截器，路由处理器已由checkExtensions确认实现handler.IInterceptable
+func (m *mServer) intercept(interceptor handler.Interceptor) {
+	m.routeHandler.(handler.IInterceptable).AddInterceptor(interceptor)
+}
+
+// listenTCP 按配置监听TCP，依次套上PROXY协议解析、准入控制及TLS
+func (m *mServer) listenTCP() (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr(m.network, net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+	if err != nil {
+		logger.Error("net.ResolveTCPAddr error,error:%+v", err)
+		return nil, err
+	}
+	network := m.network
+	if m.dualStack && (addr.IP == nil || addr.IP.IsUnspecified()) {
+		// 通配地址不指定ip族时同时监听IPv4及IPv6
+		network = "tcp"
+		addr.IP = nil
+	}
+	tcpListener, err := net.ListenTCP(network, addr)
+	if err != nil {
+		logger.Error("net.ListenTCP error,error:%+v", err)
+		return nil, err
+	}
+	var listener net.Listener = tcpListener
+	if m.proxyTrusted != nil {
+		pl, err := proxyproto.Listen(listener, m.proxyTrusted, 0)
+		if err != nil {
+			logger.Error("proxyproto.Listen error,error:%+v", err)
+			_ = tcpListener.Close()
+			return nil, err
+		}
+		listener = pl
+	}
+	if m.admission != nil {
+		listener = m.admission.Listener(listener)
+	}
+	if m.tlsConf != nil {
+		certs, err := newCertReloader(*m.tlsConf)
+		if err != nil {
+			logger.Error("load tls certificate error,error:%+v", err)
+			_ = tcpListener.Close()
+			return nil, err
+		}
+		m.certs = certs
+		listener = tls.NewListener(listener, certs.tlsConfig())
+	}
+	return listener, nil
+}
+
+// accept 循环接受连接，TLS握手完成后再按启动模式处理
+func (m *mServer) accept(listener net.Listener) {
+	for {
+		conn := connect.New(listener, m.connMgr)
+		if conn == nil {
+			continue
+		}
+		if m.tlsConf == nil {
+			m.dispatch(conn)
+			continue
+		}
+		// 握手涉及多次往返，不阻塞接受新连接
+		go func() {
+			if err := m.handshake(conn); err != nil {
+				logger.Warn("connection[id=%d,raddr:%s:%d] tls handshake error,error:%+v", conn.GetID(), conn.GetRemoteHost(), conn.GetRemotePort(), err)
+				m.release(conn)
+				return
+			}
+			m.dispatch(conn)
+		}()
+	}
+}
+
+// dispatch 按启动模式处理新接受的连接
+func (m *mServer) dispatch(conn connect.IConnection) {
+	if qc, ok := conn.(connect.IQueuedConnection); ok && m.writeQueue != nil {
+		qc.EnableWriteQueue(*m.writeQueue)
+	}
+	if m.heartbeat != nil {
+		m.heartbeat.watch(conn)
+	}
+	if m.relay != nil {
+		go func() {
+			err := m.relay.Serve(conn)
+			logger.Error("relay.Serve error,error:%+v", err)
+			m.release(conn)
+		}()
+		return
+	}
+	if m.mux {
+		go m.serveMux(conn)
+		return
+	}
+	if m.auth != nil {
+		m.auth.Watch(conn, m.release)
+	}
+	if m.reactor != nil {
+		err := m.reactor.add(conn)
+		if err == nil {
+			return
+		}
+		// 无法注册到reactor的连接(如受信上游未发送PROXY头时已预读了数据)退回由独立协程读取
+		logger.Warn("reactor.add connection[id=%d] error,fallback to goroutine,error:%+v", conn.GetID(), err)
+	}
+	go m.serve(conn)
+}
+
+// serve 循环路由连接上的报文，直到连接出错；启用处理协程池时只负责读取
+func (m *mServer) serve(conn connect.IConnection) {
+	if m.pool != nil {
+		m.read(conn)
+		return
+	}
+	r, ok := m.routeHandler.(handler.IConnRouter)
+	for {
+		var err error
+		if ok {
+			err = r.HandleConn(conn)
+		} else {
+			m.routeHandler.BindConn(conn)
+			err = m.routeHandler.HandleMsg0()
+		}
+		if err != nil {
+			logger.Error("routeHandler.HandleConn() error,error:%+v", err)
+			m.release(conn)
+			return
+		}
+	}
+}
+
+// read 循环读取连接上的报文并提交给处理协程池
+func (m *mServer) read(conn connect.IConnection) {
+	for {
+		if conn == nil || !conn.Alive() {
+			m.release(conn)
+			return
+		}
+		pkt, err := m.codec0.Decode(conn)
+		if err != nil {
+			logger.Error("msg decode error,error:%+v", err)
+			m.release(conn)
+			return
+		}
+		connect.Renew(conn)
+		if err := m.pool.submit(conn, pkt); err != nil {
+			m.release(conn)
+			return
+		}
+	}
+}
+
+// serveMux 在连接上建立复用会话，对端打开的每个流作为独立连接加入连接管理器并路由
+func (m *mServer) serveMux(conn connect.IConnection) {
+	if conn == nil {
+		return
+	}
+	session := mux.Server(conn)
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			logger.Error("mux session.Accept error,error:%+v", err)
+			m.release(conn)
+			return
+		}
+		stream.SetID(m.connMgr.GenConnID())
+		m.connMgr.AddConn(stream)
+		if m.heartbeat != nil {
+			m.heartbeat.watch(stream)
+		}
+		if m.auth != nil {
+			m.auth.Watch(stream, m.release)
+		}
+		go m.serve(stream)
+	}
+}
+
+// release 释放连接
+func (m *mServer) release(conn connect.IConnection) {
+	if conn == nil {
+		return
+	}
+	if m.reactor != nil {
+		// 关闭前注销，避免套接字关闭后fd被复用时事件投递给旧连接
+		m.reactor.remove(conn)
+	}
+	err := m.connMgr.RemoveConnByID(conn.GetID())
+	if err != nil {
+		logger.Error("remove conn error,error:%+v", err)
+	}
+	if m.cluster != nil {
+		m.cluster.UnbindConn(conn)
+	}
+	if m.tunnel != nil {
+		m.tunnel.CloseConn(conn)
+	}
+	handler.ReleaseConn(conn)
+	if m.auth != nil {
+		m.auth.Forget(conn)
+	}
+	zcodec.Forget(conn)
+	if m.limiter != nil {
+		m.limiter.Forget(conn)
+	}
+	logger.Warn("One connection[id=%d,laddr:%s:%d,raddr:%s:%d] released",
+		conn.GetID(), conn.GetLocalHost(), conn.GetLocalPort(), conn.GetRemoteHost(), conn.GetRemotePort())
+}
+
+// startHA 主备模式下standby不处理设备报文，通知设备重连primary；降级为standby时重定向已接入的设备
+func (m *mServer) startHA(ha *cluster.HA) {
+	m.intercept(func(conn connect.IConnection, pkt packet.IPacket) error {
+		if pkt.GetID() == cluster.RouteID || ha.IsPrimary() {
+			return nil
+		}
+		if err := ha.Redirect(conn); err != nil {
+			logger.Warn("server[%s] redirect connection[id=%d] error,error:%+v", m.name, conn.GetID(), err)
+		}
+		return errors.New("server " + m.name + " is standby,connection redirected to primary")
+	})
+	ha.OnRoleChange(func(role cluster.Role) {
+		if role != cluster.RoleStandby {
+			return
+		}
+		for _, conn := range m.cluster.LocalConns() {
+			if err := ha.Redirect(conn); err != nil {
+				logger.Warn("server[%s] redirect connection[id=%d] error,error:%+v", m.name, conn.GetID(), err)
+			}
+			m.release(conn)
+		}
+	})
+	ha.Start()
+}
+
+// instance 当前server在注册中心中的实例信息
+func (m *mServer) instance() *registry.ServiceInstance {
+	return &registry.ServiceInstance{
+		ID:      m.name,
+		Name:    m.service,
+		Network: m.network,
+		Host:    m.advertiseHost(),
+		Port:    m.port,
+	}
+}
+
+// advertiseHost 注册到注册中心的host，监听通配地址且未设置WithAdvertise时取本机第一个非回环地址
+func (m *mServer) advertiseHost() string {
+	if m.advertise != "" {
+		return m.advertise
+	}
+	ip := net.ParseIP(m.host)
+	if m.host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return m.host
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logger.Warn("server[%s] get interface addrs error,error:%+v", m.name, err)
+		return m.host
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	logger.Warn("server[%s] no advertisable address for wildcard host %s,use WithAdvertise", m.name, m.host)
+	return m.host
+}
+
 // 打印服务端配置信息
-func printServerEnv(m mServer) {
+func printServerEnv(m *mServer) {
 	logger.Debug("=========================================[Server-Info]=========================================")
 	logger.Debug("")
 	logger.Debug("[Name]:[%s]", m.name)
 	logger.Debug("[RouterHandler]:[%+v]", reflect.TypeOf(m.routeHandler))
+	if m.relay != nil {
+		logger.Debug("[Relay]:[%s]", m.relay.Address())
+	}
+	logger.Debug("[Mux]:[%t]", m.mux)
+	logger.Debug("[Reactor]:[loops:%d]", m.reactorLoops)
+	logger.Debug("[WorkerPool]:[workers:%d,queueSize:%d,policy:%s]", m.workers, m.queueSize, m.policy)
+	logger.Debug("[IdleTimeout]:[%s],[PingProbe]:[%s]", m.idleTimeout, m.probeWait)
+	if m.registry != nil {
+		logger.Debug("[Registry]:[%+v],[Service]:[%s]", reflect.TypeOf(m.registry), m.service)
+	}
+	if m.cluster != nil {
+		logger.Debug("[Cluster]:[%s]", m.cluster.ID())
+	}
+	if m.tlsConf != nil {
+		logger.Debug("[TLS]:[cert:%s,clientCA:%s]", m.tlsConf.CertFile, m.tlsConf.ClientCAFile)
+	}
 	logger.Debug("")
 	logger.Debug("[ChildHandlers]")
 	for id, h := range handler.AllChildHandlers() {
@@ -110,15 +684,59 @@ func printServerEnv(m mServer) {
 
 }
 
-func (m mServer) Stop() error {
+func (m *mServer) ConnMgr() connect.IConnectionMgr {
+	return m.connMgr
+}
+
+func (m *mServer) Stop() error {
+	if m.registry != nil {
+		if err := m.registry.Deregister(m.instance()); err != nil {
+			logger.Error("server[%s] deregister from registry error,error:%+v", m.name, err)
+		}
+	}
+	if m.cluster != nil {
+		if err := m.cluster.Stop(); err != nil {
+			logger.Error("server[%s] stop cluster node error,error:%+v", m.name, err)
+		}
+	}
+	if m.heartbeat != nil {
+		m.heartbeat.close()
+	}
+	if m.reactor != nil {
+		m.reactor.close()
+	}
+	if m.pool != nil {
+		m.pool.close()
+	}
+	if m.certs != nil {
+		m.certs.close()
+	}
+	if m.limiter != nil {
+		m.limiter.Stop()
+	}
+	if m.udpListener != nil {
+		if err := m.udpListener.Close(); err != nil {
+			logger.Error("server[%s] close udp listener error,error:%+v", m.name, err)
+		}
+	}
 	// close all connections for now
+	// 启用写队列时关闭连接需等待已入队的报文写出，各连接并发关闭
 	all := m.connMgr.All()
-	for _, conn := range all {
+	var wg sync.WaitGroup
+	errs := make([]error, len(all))
+	for i, conn := range all {
 		if conn != nil && conn.Alive() {
-			err := m.connMgr.RemoveConnByID(conn.GetID())
-			if err != nil {
-				return err
-			}
+			wg.Add(1)
+			go func(i int, conn connect.IConnection) {
+				defer wg.Done()
+				errs[i] = m.connMgr.RemoveConnByID(conn.GetID())
+			}(i, conn)
+		}
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
 	}
 	return nil
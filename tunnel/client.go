@@ -0,0 +1,207 @@
+package tunnel
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"dusnet/packet"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Client 设备侧隧道客户端，经设备已有的dusnet连接将本地端口暴露给服务端
+type Client struct {
+	writer  *writer
+	lock    sync.Mutex
+	exposed map[string]string        // 隧道名称 -> 本地地址
+	remote  map[string]string        // 隧道名称 -> 服务端监听地址
+	streams map[uint32]*clientStream // 流id -> 流
+}
+
+// clientStream 设备侧的一个流，本地连接建立前收到的数据先入队
+type clientStream struct {
+	local net.Conn // 连接本地服务成功前为空
+	queue *sendQueue
+}
+
+// NewClient 在设备连接conn上创建隧道客户端
+func NewClient(conn connect.IConnection) *Client {
+	return &Client{
+		writer:  &writer{conn: conn, codec0: zcodec.Default()},
+		exposed: make(map[string]string),
+		remote:  make(map[string]string),
+		streams: make(map[uint32]*clientStream),
+	}
+}
+
+// SetCodec 设置隧道报文使用的编解码器
+func (c *Client) SetCodec(codec zcodec.Icodec) {
+	c.writer.codec0 = codec
+}
+
+// Expose 将本地地址localAddr以name暴露给服务端，服务端接受后即可经其监听端口访问
+func (c *Client) Expose(name string, localAddr string) error {
+	if name == "" || strings.Contains(name, "\n") {
+		return errors.New("invalid tunnel name")
+	}
+	c.lock.Lock()
+	c.exposed[name] = localAddr
+	c.lock.Unlock()
+	return c.writer.write(frameRegister, 0, []byte(name))
+}
+
+// RemoteAddress 返回服务端为隧道打开的监听地址，服务端尚未接受时为空
+func (c *Client) RemoteAddress(name string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.remote[name]
+}
+
+// Serve 循环读取设备连接上的报文并处理隧道报文，适用于连接仅用于隧道的场景
+func (c *Client) Serve() error {
+	for {
+		pkt, err := c.writer.codec0.Decode(c.writer.conn)
+		if err != nil {
+			c.closeAll()
+			return err
+		}
+		if pkt.GetID() != RouteID {
+			continue
+		}
+		if err := c.Handle(pkt); err != nil {
+			logger.Warn("tunnel client handle msg error,error:%+v", err)
+		}
+	}
+}
+
+// Handle 处理服务端下发的隧道报文，设备自行读取连接时对RouteID路由的报文调用
+func (c *Client) Handle(pkt packet.IPacket) error {
+	op, stream, payload, err := decodeFrame(pkt.GetData())
+	if err != nil {
+		return err
+	}
+	switch op {
+	case frameAccepted, frameRejected:
+		parts := strings.SplitN(string(payload), "\n", 2)
+		if len(parts) != 2 {
+			return errInvalidFrame
+		}
+		if op == frameRejected {
+			logger.Warn("tunnel[%s] rejected by server,reason:%s", parts[0], parts[1])
+			return nil
+		}
+		c.lock.Lock()
+		c.remote[parts[0]] = parts[1]
+		c.lock.Unlock()
+		logger.Info("tunnel[%s] accepted by server on %s", parts[0], parts[1])
+	case frameOpen:
+		c.lock.Lock()
+		localAddr, ok := c.exposed[string(payload)]
+		c.lock.Unlock()
+		if !ok {
+			return c.writer.write(frameClose, stream, nil)
+		}
+		s := &clientStream{queue: newSendQueue()}
+		c.lock.Lock()
+		c.streams[stream] = s
+		c.lock.Unlock()
+		go c.open(stream, string(payload), localAddr, s)
+	case frameData:
+		c.lock.Lock()
+		s, ok := c.streams[stream]
+		c.lock.Unlock()
+		if !ok {
+			return nil
+		}
+		if !s.queue.push(payload) {
+			logger.Warn("tunnel stream[%d] local too slow,pending exceeds %d bytes", stream, maxPending)
+			c.closeStream(stream, true)
+		}
+	case frameClose:
+		c.closeStream(stream, false)
+	default:
+		return errInvalidFrame
+	}
+	return nil
+}
+
+// open 连接本地服务，之后由本协程将服务端数据写入本地连接
+func (c *Client) open(stream uint32, name string, localAddr string, s *clientStream) {
+	local, err := net.DialTimeout("tcp", localAddr, dialTimeout)
+	if err != nil {
+		logger.Warn("tunnel[%s] dial local %s error,error:%+v", name, localAddr, err)
+		c.closeStream(stream, true)
+		return
+	}
+	c.lock.Lock()
+	s.local = local
+	c.lock.Unlock()
+	go c.pump(stream, local)
+	err = s.queue.drain(local)
+	_ = local.Close()
+	if err != nil {
+		logger.Warn("tunnel[%s] write local %s error,error:%+v", name, localAddr, err)
+		c.closeStream(stream, true)
+	}
+}
+
+// pump 将本地数据转发给服务端
+func (c *Client) pump(stream uint32, local net.Conn) {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := local.Read(buf)
+		if n > 0 {
+			if werr := c.writer.write(frameData, stream, buf[:n]); werr != nil {
+				c.closeStream(stream, false)
+				return
+			}
+		}
+		if err != nil {
+			c.closeStream(stream, true)
+			return
+		}
+	}
+}
+
+// closeStream 关闭流，notify为true时本地出错，丢弃未写出的数据并通知服务端；
+// 否则为服务端关闭，写完已收到的数据后关闭本地连接
+func (c *Client) closeStream(stream uint32, notify bool) {
+	c.lock.Lock()
+	s, ok := c.streams[stream]
+	delete(c.streams, stream)
+	c.lock.Unlock()
+	if !ok {
+		return
+	}
+	if !notify {
+		s.queue.finish()
+		return
+	}
+	s.queue.abort()
+	if local := c.localOf(s); local != nil {
+		_ = local.Close()
+	}
+	_ = c.writer.write(frameClose, stream, nil)
+}
+
+func (c *Client) closeAll() {
+	c.lock.Lock()
+	streams := c.streams
+	c.streams = make(map[uint32]*clientStream)
+	c.lock.Unlock()
+	for _, s := range streams {
+		s.queue.abort()
+		if local := c.localOf(s); local != nil {
+			_ = local.Close()
+		}
+	}
+}
+
+// localOf 返回流的本地连接，连接本地服务成功前为空
+func (c *Client) localOf(s *clientStream) net.Conn {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return s.local
+}
@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/packet"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// RouteID 隧道使用的路由id，隧道数据经设备已有的dusnet连接在该路由上传输
+const RouteID uint32 = 7000
+
+// 隧道帧类型
+const (
+	frameRegister byte = iota + 1 // 设备注册隧道，载荷为隧道名称
+	frameAccepted                 // 服务端接受注册，载荷为 隧道名称 + "\n" + 服务端监听地址
+	frameRejected                 // 服务端拒绝注册，载荷为 隧道名称 + "\n" + 原因
+	frameOpen                     // 服务端有新的访问连接，载荷为隧道名称
+	frameData                     // 流数据
+	frameClose                    // 流关闭
+)
+
+const (
+	frameHeadLen = 5         // 帧头长度 op(1) + 流id(4)
+	chunkSize    = 16 * 1024 // 单帧最大数据
+)
+
+var errInvalidFrame = errors.New("invalid tunnel frame")
+
+func encodeFrame(op byte, stream uint32, payload []byte) []byte {
+	buf := make([]byte, frameHeadLen+len(payload))
+	buf[0] = op
+	binary.BigEndian.PutUint32(buf[1:frameHeadLen], stream)
+	copy(buf[frameHeadLen:], payload)
+	return buf
+}
+
+func decodeFrame(data []byte) (byte, uint32, []byte, error) {
+	if len(data) < frameHeadLen {
+		return 0, 0, nil, errInvalidFrame
+	}
+	return data[0], binary.BigEndian.Uint32(data[1:frameHeadLen]), data[frameHeadLen:], nil
+}
+
+// writer 在dusnet连接上写入隧道帧，同一连接上的多个流并发写入时保证报文不交错
+type writer struct {
+	conn   connect.IConnection
+	codec0 zcodec.Icodec
+	lock   sync.Mutex
+	nextID uint32 // 流id在设备连接内统一分配，同一连接上的多个隧道不会重复
+}
+
+// nextStream 分配设备连接上的下一个流id
+func (w *writer) nextStream() uint32 {
+	return atomic.AddUint32(&w.nextID, 1)
+}
+
+func (w *writer) write(op byte, stream uint32, payload []byte) error {
+	pkt := &packet.Packet{}
+	pkt.ID = RouteID
+	pkt.Type = zcodec.TYPE_BUSINESS
+	pkt.Data = encodeFrame(op, stream, payload)
+	buf, err := zcodec.EncodeFor(w.codec0, w.conn, pkt)
+	if err != nil {
+		return err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.conn.Write(buf)
+}
@@ -0,0 +1,354 @@
+package tunnel
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"dusnet/logger"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Authorizer 判断设备连接是否允许注册指定名称的隧道
+type Authorizer func(conn connect.IConnection, name string) bool
+
+// Option 隧道管理器可选配置
+type Option func(*Manager)
+
+// WithListenHost 设置隧道监听的host，默认仅监听127.0.0.1
+func WithListenHost(host string) Option {
+	return func(m *Manager) {
+		m.listenHost = host
+	}
+}
+
+// WithAuthorizer 设置设备注册隧道的鉴权，未设置时拒绝所有注册
+func WithAuthorizer(authorize Authorizer) Option {
+	return func(m *Manager) {
+		m.authorize = authorize
+	}
+}
+
+// WithAllowCIDRs 设置允许访问隧道监听端口的来源网段，未设置时不限制
+func WithAllowCIDRs(cidrs ...string) Option {
+	return func(m *Manager) {
+		for _, cidr := range cidrs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				logger.Error("tunnel allow cidr[%s] invalid,error:%+v", cidr, err)
+				continue
+			}
+			m.allow = append(m.allow, ipNet)
+		}
+	}
+}
+
+// Manager 服务端隧道管理器，为设备注册的每个隧道在本地打开监听端口，
+// 访问该端口的连接经设备的dusnet连接转发到设备本地端口
+type Manager struct {
+	codec0     zcodec.Icodec
+	listenHost string
+	authorize  Authorizer
+	allow      []*net.IPNet
+	lock       sync.Mutex
+	tunnels    map[string]*Tunnel // 设备连接id/隧道名称 -> 隧道
+	writers    map[connect.IConnection]*writer
+}
+
+// NewManager 返回隧道管理器
+func NewManager(opts ...Option) *Manager {
+	m := &Manager{
+		codec0:     zcodec.Default(),
+		listenHost: "127.0.0.1",
+		tunnels:    make(map[string]*Tunnel),
+		writers:    make(map[connect.IConnection]*writer),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// SetCodec 设置隧道报文使用的编解码器
+func (m *Manager) SetCodec(codec zcodec.Icodec) {
+	m.codec0 = codec
+}
+
+// Tunnel 服务端的一个隧道
+type Tunnel struct {
+	Name     string
+	conn     connect.IConnection // 设备连接
+	writer   *writer
+	listener net.Listener
+	lock     sync.Mutex
+	streams  map[uint32]*accessStream // 流id -> 访问连接
+	bytesIn  uint64                   // 设备发往访问方的字节数
+	bytesOut uint64                   // 访问方发往设备的字节数
+}
+
+// accessStream 服务端的一个流，设备发来的数据入队后由流的写协程写入访问连接
+type accessStream struct {
+	conn  net.Conn
+	queue *sendQueue
+}
+
+// Stat 隧道统计
+type Stat struct {
+	Name     string `json:"name"`
+	Device   string `json:"device"`  // 设备地址
+	Address  string `json:"address"` // 服务端监听地址
+	Streams  int    `json:"streams"` // 当前访问连接数
+	BytesIn  uint64 `json:"bytesIn"`
+	BytesOut uint64 `json:"bytesOut"`
+}
+
+// Stats 返回所有隧道的统计
+func (m *Manager) Stats() []Stat {
+	m.lock.Lock()
+	tunnels := make([]*Tunnel, 0, len(m.tunnels))
+	for _, t := range m.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	m.lock.Unlock()
+	stats := make([]Stat, 0, len(tunnels))
+	for _, t := range tunnels {
+		t.lock.Lock()
+		streams := len(t.streams)
+		t.lock.Unlock()
+		stats = append(stats, Stat{
+			Name:     t.Name,
+			Device:   connect.RemoteAddrPortOf(t.conn).String(),
+			Address:  t.listener.Addr().String(),
+			Streams:  streams,
+			BytesIn:  atomic.LoadUint64(&t.bytesIn),
+			BytesOut: atomic.LoadUint64(&t.bytesOut),
+		})
+	}
+	return stats
+}
+
+func tunnelKey(conn connect.IConnection, name string) string {
+	return fmt.Sprintf("%d/%s", conn.GetID(), name)
+}
+
+// Handle 处理设备经conn发来的隧道帧
+func (m *Manager) Handle(conn connect.IConnection, data []byte) error {
+	op, stream, payload, err := decodeFrame(data)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case frameRegister:
+		m.register(conn, string(payload))
+	case frameData:
+		t, s := m.stream(conn, stream)
+		if s == nil {
+			return nil
+		}
+		atomic.AddUint64(&t.bytesIn, uint64(len(payload)))
+		if !s.queue.push(payload) {
+			logger.Warn("tunnel[%s] stream[%d] access too slow,pending exceeds %d bytes", t.Name, stream, maxPending)
+			t.closeStream(stream, true)
+		}
+	case frameClose:
+		if t, s := m.stream(conn, stream); s != nil {
+			t.closeStream(stream, false)
+		}
+	default:
+		return errInvalidFrame
+	}
+	return nil
+}
+
+func (m *Manager) register(conn connect.IConnection, name string) {
+	w := m.writer(conn)
+	if m.authorize == nil || !m.authorize(conn, name) {
+		logger.Warn("tunnel[%s] register from %s:%d rejected", name, conn.GetRemoteHost(), conn.GetRemotePort())
+		_ = w.write(frameRejected, 0, []byte(name+"\nunauthorized"))
+		return
+	}
+	key := tunnelKey(conn, name)
+	m.lock.Lock()
+	old, ok := m.tunnels[key]
+	delete(m.tunnels, key)
+	m.lock.Unlock()
+	if ok {
+		// 设备重复注册，关闭旧隧道
+		old.close()
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(m.listenHost, "0"))
+	if err != nil {
+		logger.Error("tunnel[%s] listen error,error:%+v", name, err)
+		_ = w.write(frameRejected, 0, []byte(name+"\n"+err.Error()))
+		return
+	}
+	t := &Tunnel{
+		Name:     name,
+		conn:     conn,
+		writer:   w,
+		listener: listener,
+		streams:  make(map[uint32]*accessStream),
+	}
+	m.lock.Lock()
+	m.tunnels[key] = t
+	m.lock.Unlock()
+	logger.Info("tunnel[%s] of device %s:%d listening on %s", name, conn.GetRemoteHost(), conn.GetRemotePort(), listener.Addr())
+	_ = w.write(frameAccepted, 0, []byte(name+"\n"+listener.Addr().String()))
+	go m.accept(t)
+}
+
+func (m *Manager) accept(t *Tunnel) {
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !m.allowed(c.RemoteAddr()) {
+			logger.Warn("tunnel[%s] access from %s denied", t.Name, c.RemoteAddr())
+			_ = c.Close()
+			continue
+		}
+		if !t.conn.Alive() {
+			_ = c.Close()
+			m.CloseConn(t.conn)
+			return
+		}
+		id := t.writer.nextStream()
+		s := &accessStream{conn: c, queue: newSendQueue()}
+		t.lock.Lock()
+		t.streams[id] = s
+		t.lock.Unlock()
+		go t.send(id, s)
+		if err := t.writer.write(frameOpen, id, []byte(t.Name)); err != nil {
+			t.closeStream(id, false)
+			continue
+		}
+		go t.pump(id, c)
+	}
+}
+
+func (m *Manager) allowed(addr net.Addr) bool {
+	if len(m.allow) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range m.allow {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) writer(conn connect.IConnection) *writer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	w, ok := m.writers[conn]
+	if !ok {
+		w = &writer{conn: conn, codec0: m.codec0}
+		m.writers[conn] = w
+	}
+	return w
+}
+
+func (m *Manager) stream(conn connect.IConnection, id uint32) (*Tunnel, *accessStream) {
+	m.lock.Lock()
+	var tunnels []*Tunnel
+	for _, t := range m.tunnels {
+		if t.conn == conn {
+			tunnels = append(tunnels, t)
+		}
+	}
+	m.lock.Unlock()
+	for _, t := range tunnels {
+		t.lock.Lock()
+		s, ok := t.streams[id]
+		t.lock.Unlock()
+		if ok {
+			return t, s
+		}
+	}
+	return nil, nil
+}
+
+// CloseConn 设备连接断开时关闭其上的所有隧道
+func (m *Manager) CloseConn(conn connect.IConnection) {
+	m.lock.Lock()
+	var tunnels []*Tunnel
+	for key, t := range m.tunnels {
+		if t.conn == conn {
+			tunnels = append(tunnels, t)
+			delete(m.tunnels, key)
+		}
+	}
+	delete(m.writers, conn)
+	m.lock.Unlock()
+	for _, t := range tunnels {
+		t.close()
+	}
+}
+
+// pump 将访问方数据转发给设备
+func (t *Tunnel) pump(id uint32, c net.Conn) {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := c.Read(buf)
+		if n > 0 {
+			atomic.AddUint64(&t.bytesOut, uint64(n))
+			if werr := t.writer.write(frameData, id, buf[:n]); werr != nil {
+				t.closeStream(id, false)
+				return
+			}
+		}
+		if err != nil {
+			t.closeStream(id, true)
+			return
+		}
+	}
+}
+
+// send 将设备数据写入访问连接，写完或出错后关闭访问连接
+func (t *Tunnel) send(id uint32, s *accessStream) {
+	err := s.queue.drain(s.conn)
+	_ = s.conn.Close()
+	if err != nil {
+		logger.Warn("tunnel[%s] write stream[%d] error,error:%+v", t.Name, id, err)
+		t.closeStream(id, true)
+	}
+}
+
+// closeStream 关闭流，notify为true时访问方出错，丢弃未写出的数据并通知设备关闭本地连接；
+// 否则写完已收到的数据后关闭访问连接
+func (t *Tunnel) closeStream(id uint32, notify bool) {
+	t.lock.Lock()
+	s, ok := t.streams[id]
+	delete(t.streams, id)
+	t.lock.Unlock()
+	if !ok {
+		return
+	}
+	if !notify {
+		s.queue.finish()
+		return
+	}
+	s.queue.abort()
+	_ = s.conn.Close()
+	_ = t.writer.write(frameClose, id, nil)
+}
+
+func (t *Tunnel) close() {
+	_ = t.listener.Close()
+	t.lock.Lock()
+	streams := t.streams
+	t.streams = make(map[uint32]*accessStream)
+	t.lock.Unlock()
+	for _, s := range streams {
+		s.queue.abort()
+		_ = s.conn.Close()
+	}
+	logger.Info("tunnel[%s] on %s closed", t.Name, t.listener.Addr())
+}
@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout  = 5 * time.Second  // 设备连接本地服务的超时
+	writeTimeout = 10 * time.Second // 单次写入访问连接或本地连接的超时
+	maxPending   = 1 << 20          // 流待写入数据上限，超过时视为对端过慢并关闭流
+)
+
+// sendQueue 流的待写入数据，由流自己的写协程按序写出，
+// 慢的访问方或本地服务不会阻塞设备连接上其他流的报文
+type sendQueue struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	bufs    net.Buffers
+	pending int
+	done    bool // 不再入队，写完已入队的数据后退出
+	aborted bool // 丢弃未写出的数据立即退出
+}
+
+func newSendQueue() *sendQueue {
+	q := &sendQueue{}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// push 入队一段数据，队列已关闭或超过上限时返回false
+func (q *sendQueue) push(data []byte) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.done || q.aborted || q.pending+len(data) > maxPending {
+		return false
+	}
+	q.bufs = append(q.bufs, data)
+	q.pending += len(data)
+	q.cond.Signal()
+	return true
+}
+
+// finish 写完已入队的数据后结束
+func (q *sendQueue) finish() {
+	q.lock.Lock()
+	q.done = true
+	q.lock.Unlock()
+	q.cond.Broadcast()
+}
+
+// abort 丢弃未写出的数据立即结束
+func (q *sendQueue) abort() {
+	q.lock.Lock()
+	q.aborted = true
+	q.bufs = nil
+	q.lock.Unlock()
+	q.cond.Broadcast()
+}
+
+// drain 将入队的数据按序写入conn直到finish或abort，每次写入受writeTimeout限制
+func (q *sendQueue) drain(conn net.Conn) error {
+	for {
+		q.lock.Lock()
+		for len(q.bufs) == 0 && !q.done && !q.aborted {
+			q.cond.Wait()
+		}
+		if q.aborted || len(q.bufs) == 0 {
+			q.lock.Unlock()
+			return nil
+		}
+		bufs := q.bufs
+		q.bufs = nil
+		q.pending = 0
+		q.lock.Unlock()
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := bufs.WriteTo(conn); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package tunnel
+
+import (
+	zcodec "dusnet/codec"
+	"dusnet/connect"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// device 建立一条设备连接，服务端在该连接上由manager处理隧道报文，返回设备侧的隧道客户端
+func device(t *testing.T, manager *Manager) *Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error:%+v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	dialed := make(chan connect.IConnection, 1)
+	go func() {
+		conn, err := connect.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Errorf("dial error:%+v", err)
+		}
+		dialed <- conn
+	}()
+	server := connect.New(l, connect.DefaultConnMgr())
+	conn := <-dialed
+	if server == nil || conn == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = server.Close()
+		manager.CloseConn(server)
+	})
+	go func() {
+		codec := zcodec.Default()
+		for {
+			pkt, err := codec.Decode(server)
+			if err != nil {
+				return
+			}
+			_ = manager.Handle(server, pkt.GetData())
+		}
+	}()
+	client := NewClient(conn)
+	go func() { _ = client.Serve() }()
+	return client
+}
+
+// echo 启动本地服务，为收到的每段数据加上前缀prefix后原样返回
+func echo(t *testing.T, prefix string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error:%+v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 1024)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					if _, err := c.Write(append([]byte(prefix), buf[:n]...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// expose 暴露本地地址并等待服务端接受，返回服务端监听地址
+func expose(t *testing.T, client *Client, name string, localAddr string) string {
+	if err := client.Expose(name, localAddr); err != nil {
+		t.Fatalf("expose %s error:%+v", name, err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if addr := client.RemoteAddress(name); addr != "" {
+			return addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("tunnel %s not accepted", name)
+	return ""
+}
+
+func TestTunnelStreamsOnSharedConn(t *testing.T) {
+	manager := NewManager(WithAuthorizer(func(connect.IConnection, string) bool { return true }))
+	client := device(t, manager)
+	remotes := map[string]string{
+		"a:": expose(t, client, "a", echo(t, "a:")),
+		"b:": expose(t, client, "b", echo(t, "b:")),
+	}
+	// 两个隧道各自打开多个流，流id在同一设备连接上不能冲突
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for prefix, remote := range remotes {
+			wg.Add(1)
+			go func(prefix string, remote string) {
+				defer wg.Done()
+				c, err := net.Dial("tcp", remote)
+				if err != nil {
+					t.Errorf("dial tunnel error:%+v", err)
+					return
+				}
+				defer c.Close()
+				_ = c.SetDeadline(time.Now().Add(3 * time.Second))
+				for j := 0; j < 10; j++ {
+					if _, err := c.Write([]byte("ping")); err != nil {
+						t.Errorf("write tunnel error:%+v", err)
+						return
+					}
+					reply := make([]byte, len(prefix)+4)
+					if _, err := io.ReadFull(c, reply); err != nil {
+						t.Errorf("read tunnel error:%+v", err)
+						return
+					}
+					if string(reply) != prefix+"ping" {
+						t.Errorf("tunnel %s got reply %q", prefix, reply)
+						return
+					}
+				}
+			}(prefix, remote)
+		}
+	}
+	wg.Wait()
+}
+
+// stall 启动只接受连接不读取数据的本地服务
+func stall(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error:%+v", err)
+	}
+	var conns []net.Conn
+	var lock sync.Mutex
+	t.Cleanup(func() {
+		_ = l.Close()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			conns = append(conns, c)
+			lock.Unlock()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestTunnelSlowLocalDoesNotBlockConn(t *testing.T) {
+	manager := NewManager(WithAuthorizer(func(connect.IConnection, string) bool { return true }))
+	client := device(t, manager)
+	fast := expose(t, client, "fast", echo(t, "fast:"))
+	slow := expose(t, client, "slow", stall(t))
+	c, err := net.Dial("tcp", slow)
+	if err != nil {
+		t.Fatalf("dial tunnel error:%+v", err)
+	}
+	defer c.Close()
+	// 本地服务不读取，写满其接收缓冲后设备连接上的其他流仍应正常转发
+	go func() {
+		chunk := make([]byte, 64*1024)
+		_ = c.SetWriteDeadline(time.Now().Add(3 * time.Second))
+		for i := 0; i < 256; i++ {
+			if _, err := c.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	f, err := net.Dial("tcp", fast)
+	if err != nil {
+		t.Fatalf("dial tunnel error:%+v", err)
+	}
+	defer f.Close()
+	_ = f.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := f.Write([]byte("ping")); err != nil {
+		t.Fatalf("write tunnel error:%+v", err)
+	}
+	reply := make([]byte, len("fast:ping"))
+	if _, err := io.ReadFull(f, reply); err != nil {
+		t.Fatalf("read tunnel error:%+v", err)
+	}
+	if string(reply) != "fast:ping" {
+		t.Fatalf("got reply %q", reply)
+	}
+}